@@ -0,0 +1,86 @@
+package payloadqueue
+
+import (
+	"errors"
+	"strconv"
+)
+
+// Adaptive makes a RateQueue adjust its rate to the Work results (AIMD): the rate grows
+// by Increase after every success and is multiplied by Decrease when a result is
+// classified as throttled. Rates are in requests per Per, like RateQueue.Rate.
+type Adaptive struct {
+	MinRate   float64               // Default is 1% of MaxRate
+	MaxRate   float64               // Default is the RateQueue Rate
+	Increase  float64               // Default is 1
+	Decrease  float64               // 0 to 1. Default is 0.5
+	Throttled func(result int) bool // Default treats 429 as throttled
+}
+
+// init to validate the settings and apply the defaults for the starting rate
+func (a *Adaptive) init(rate float64) error {
+	if a.MaxRate == 0 {
+		a.MaxRate = rate
+	}
+	if a.MinRate == 0 {
+		a.MinRate = a.MaxRate / 100
+	}
+	if a.Increase == 0 {
+		a.Increase = 1
+	}
+	if a.Decrease == 0 {
+		a.Decrease = 0.5
+	}
+	if a.Throttled == nil {
+		a.Throttled = func(result int) bool { return result == 429 }
+	}
+	if a.MinRate <= 0 || a.MaxRate < a.MinRate {
+		return errors.New("the Adaptive MinRate and MaxRate must be above zero and in order")
+	}
+	if a.Decrease >= 1 || a.Decrease < 0 {
+		return errors.New("the Adaptive Decrease must be between 0 and 1")
+	}
+	return nil
+}
+
+// clamp to keep the rate within MinRate and MaxRate
+func (a *Adaptive) clamp(rate float64) float64 {
+	if rate < a.MinRate {
+		return a.MinRate
+	}
+	if rate > a.MaxRate {
+		return a.MaxRate
+	}
+	return rate
+}
+
+// adapt to change the rate after a Work result when the RateQueue is Adaptive
+func (q *TypedRateQueue[T]) adapt(result int) {
+	if q.Adaptive == nil || q.bucket == nil {
+		return
+	}
+	q.adaptMutex.Lock()
+	defer q.adaptMutex.Unlock()
+	current := q.EffectiveRate()
+	rate := current
+	switch {
+	case q.Adaptive.Throttled(result):
+		rate = q.Adaptive.clamp(current * q.Adaptive.Decrease)
+	case result == 0:
+		rate = q.Adaptive.clamp(current + q.Adaptive.Increase)
+	}
+	if rate == current {
+		return
+	}
+	q.bucket.setRate(q.clock().Now(), rate/q.Per.Seconds())
+	q.metrics().Rate(q.Tag, rate)
+	q.event(Event{Kind: EventRateChanged, Result: result, Message: "Rate Changed: " + strconv.FormatFloat(rate, 'f', -1, 64) + " per " + q.Per.String() + ". Result: " + strconv.Itoa(result)})
+}
+
+// EffectiveRate to return the current rate in requests per Per. It only differs from
+// Rate when the RateQueue is Adaptive.
+func (q *TypedRateQueue[T]) EffectiveRate() float64 {
+	if q.bucket == nil {
+		return q.Rate
+	}
+	return q.bucket.currentRate() * q.Per.Seconds()
+}
@@ -0,0 +1,90 @@
+package payloadqueue_test
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sam-ish/payloadqueue"
+)
+
+func TestRateQAdaptive(t *testing.T) {
+	t.Run("Rate decreases on throttling and increases on success", func(t *testing.T) {
+		var feedMutex sync.Mutex
+		changes := 0
+		results := []int{429, 429, 0, 0}
+
+		var runMutex sync.Mutex
+		q := &payloadqueue.RateQueue{
+			Rate:     40,
+			Adaptive: &payloadqueue.Adaptive{MinRate: 5, Increase: 2},
+			Tag:      "RateQueueAdaptive",
+			Work: func(pl interface{}) int {
+				runMutex.Lock()
+				defer runMutex.Unlock()
+				result := results[0]
+				results = results[1:]
+				return result
+			},
+			EventFeed: func(s string) {
+				feedMutex.Lock()
+				if strings.Contains(s, "Rate Changed") {
+					changes++
+				}
+				feedMutex.Unlock()
+			},
+		}
+		q.Start()
+		for i := 0; i < 4; i++ {
+			q.Append(payloadqueue.Payload{Id: strconv.Itoa(i)})
+		}
+		time.Sleep(time.Second)
+
+		// 40 * 0.5 * 0.5 + 2 + 2
+		if rate := q.EffectiveRate(); rate != 14 {
+			t.Errorf("Expected an effective rate of 14, got %f", rate)
+		}
+		feedMutex.Lock()
+		if changes != 4 {
+			t.Errorf("Expected 4 rate changes, got %d", changes)
+		}
+		feedMutex.Unlock()
+		q.Close()
+	})
+
+	t.Run("Rate stays within the bounds", func(t *testing.T) {
+		q := &payloadqueue.RateQueue{
+			Rate: 100,
+			Adaptive: &payloadqueue.Adaptive{
+				MinRate:   60,
+				MaxRate:   100,
+				Throttled: payloadqueue.RetryCodes(503),
+			},
+			Tag:  "RateQueueAdaptive",
+			Work: func(pl interface{}) int { return 503 },
+		}
+		q.Start()
+		for i := 0; i < 3; i++ {
+			q.Append(payloadqueue.Payload{Id: strconv.Itoa(i)})
+		}
+		time.Sleep(200 * time.Millisecond)
+		if rate := q.EffectiveRate(); rate != 60 {
+			t.Errorf("Expected the rate to stop at MinRate 60, got %f", rate)
+		}
+		q.Close()
+	})
+
+	t.Run("Invalid bounds are rejected", func(t *testing.T) {
+		q := &payloadqueue.RateQueue{
+			Rate:     10,
+			Adaptive: &payloadqueue.Adaptive{MinRate: 20, MaxRate: 10},
+			Tag:      "RateQueueAdaptive",
+			Work:     func(pl interface{}) int { return 0 },
+		}
+		if err := q.Start(); err == nil {
+			t.Errorf("Expected an error for MinRate above MaxRate")
+		}
+	})
+}
@@ -0,0 +1,289 @@
+package payloadqueue
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// errBreakerOpen is returned when Work is not called because the Breaker is open
+var errBreakerOpen = errors.New("the circuit breaker is open")
+
+// BreakerState is the state of a CircuitBreaker
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // Work is called as usual
+	BreakerOpen                         // Work is not called and the payloads are held
+	BreakerHalfOpen                     // a few trial calls decide if the breaker closes again
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// CircuitBreaker stops a queue from calling Work while the downstream is failing. It
+// opens once the failure ratio within Window reaches FailureRatio, stays open for
+// CoolDown and then lets HalfOpenRequests trial calls through: a success closes it
+// again, a failure opens it for another CoolDown. A breaker can be shared by queues.
+type CircuitBreaker struct {
+	FailureRatio     float64       // 0 to 1. Default is 0.5
+	MinRequests      int           // calls within Window before the ratio is checked. Default is 10
+	Window           time.Duration // Default is 1 minute
+	CoolDown         time.Duration // Default is 30 seconds
+	HalfOpenRequests int           // Default is 1
+	Clock            Clock         // Default is the Clock of the queue it is started with
+	breakerMutex     sync.Mutex
+	state            BreakerState
+	windowStart      time.Time
+	successes        int
+	failures         int
+	openedAt         time.Time
+	trials           int // calls let through while half-open
+}
+
+// State to return the current state of the breaker
+func (b *CircuitBreaker) State() BreakerState {
+	b.breakerMutex.Lock()
+	defer b.breakerMutex.Unlock()
+	b.coolDown(b.now())
+	return b.state
+}
+
+// Allow to check if Work can be called. A half-open breaker counts the call as a trial.
+func (b *CircuitBreaker) Allow() bool {
+	allowed, _ := b.allow()
+	return allowed
+}
+
+// Record to count the outcome of a Work call
+func (b *CircuitBreaker) Record(success bool) {
+	b.record(success)
+}
+
+// now to return the time of the breaker Clock
+func (b *CircuitBreaker) now() time.Time {
+	return clockOr(b.Clock).Now()
+}
+
+// allow to check if Work can be called, returning the new state when it changed
+func (b *CircuitBreaker) allow() (bool, *BreakerState) {
+	b.breakerMutex.Lock()
+	defer b.breakerMutex.Unlock()
+	changed := b.coolDown(b.now())
+	switch b.state {
+	case BreakerOpen:
+		return false, changed
+	case BreakerHalfOpen:
+		if b.trials >= b.halfOpenRequests() {
+			return false, changed
+		}
+		b.trials++
+	}
+	return true, changed
+}
+
+// ready to check, without counting a trial, if Allow would let a call through. When
+// the breaker is open, the time left until it turns half-open is returned.
+func (b *CircuitBreaker) ready() (bool, time.Duration) {
+	b.breakerMutex.Lock()
+	defer b.breakerMutex.Unlock()
+	now := b.now()
+	b.coolDown(now)
+	switch b.state {
+	case BreakerOpen:
+		return false, b.openedAt.Add(b.coolDownPeriod()).Sub(now)
+	case BreakerHalfOpen:
+		return b.trials < b.halfOpenRequests(), 0
+	}
+	return true, 0
+}
+
+// record to count the outcome of a Work call, returning the new state when it changed
+func (b *CircuitBreaker) record(success bool) *BreakerState {
+	b.breakerMutex.Lock()
+	defer b.breakerMutex.Unlock()
+	now := b.now()
+	switch b.state {
+	case BreakerHalfOpen:
+		if success {
+			return b.set(BreakerClosed, now)
+		}
+		return b.set(BreakerOpen, now)
+	case BreakerOpen:
+		return nil
+	}
+	if b.windowStart.IsZero() || now.Sub(b.windowStart) > b.window() {
+		b.windowStart, b.successes, b.failures = now, 0, 0
+	}
+	if success {
+		b.successes++
+		return nil
+	}
+	b.failures++
+	total := b.successes + b.failures
+	if total >= b.minRequests() && float64(b.failures)/float64(total) >= b.failureRatio() {
+		return b.set(BreakerOpen, now)
+	}
+	return nil
+}
+
+// release to give back a half-open trial that did not end up calling Work
+func (b *CircuitBreaker) release() {
+	b.breakerMutex.Lock()
+	defer b.breakerMutex.Unlock()
+	if b.state == BreakerHalfOpen && b.trials > 0 {
+		b.trials--
+	}
+}
+
+// coolDown to turn an open breaker half-open once CoolDown has elapsed
+func (b *CircuitBreaker) coolDown(now time.Time) *BreakerState {
+	if b.state == BreakerOpen && now.Sub(b.openedAt) >= b.coolDownPeriod() {
+		return b.set(BreakerHalfOpen, now)
+	}
+	return nil
+}
+
+// set to move the breaker to the state and reset its counters
+func (b *CircuitBreaker) set(state BreakerState, now time.Time) *BreakerState {
+	b.state = state
+	b.trials = 0
+	b.windowStart, b.successes, b.failures = now, 0, 0
+	if state == BreakerOpen {
+		b.openedAt = now
+	}
+	return &state
+}
+
+func (b *CircuitBreaker) failureRatio() float64 {
+	if b.FailureRatio == 0 {
+		return 0.5
+	}
+	return b.FailureRatio
+}
+
+func (b *CircuitBreaker) minRequests() int {
+	if b.MinRequests == 0 {
+		return 10
+	}
+	return b.MinRequests
+}
+
+func (b *CircuitBreaker) window() time.Duration {
+	if b.Window == 0 {
+		return time.Minute
+	}
+	return b.Window
+}
+
+func (b *CircuitBreaker) coolDownPeriod() time.Duration {
+	if b.CoolDown == 0 {
+		return 30 * time.Second
+	}
+	return b.CoolDown
+}
+
+func (b *CircuitBreaker) halfOpenRequests() int {
+	if b.HalfOpenRequests == 0 {
+		return 1
+	}
+	return b.HalfOpenRequests
+}
+
+// allow to consult the Breaker, if any, before Work is called. The state change is
+// returned for breakerChanged, since the callers hold payloadMutex at this point.
+func (q *TypedQueue[T]) allow() (bool, *BreakerState) {
+	if q.Breaker == nil {
+		return true, nil
+	}
+	return q.Breaker.allow()
+}
+
+// record to count the outcome of a batch in the Breaker, if any
+func (q *TypedQueue[T]) record(success bool) {
+	if q.Breaker != nil {
+		q.breakerChanged(q.Breaker.record(success))
+	}
+}
+
+func (q *TypedQueue[T]) breakerChanged(state *BreakerState) {
+	if state != nil {
+		q.event(breakerEvent(*state))
+	}
+}
+
+// breakerChanged to hold the event of a Breaker state change until payloadMutex is released
+func (h *held) breakerChanged(state *BreakerState) {
+	if state != nil {
+		h.event(breakerEvent(*state))
+	}
+}
+
+// breakerEvent to return the event of a Breaker state change
+func breakerEvent(state BreakerState) Event {
+	return Event{Kind: EventBreaker, Level: breakerLevel(state), Message: "Circuit Breaker: " + state.String()}
+}
+
+// breakerLevel to return the event level of a Breaker state change
+func breakerLevel(state BreakerState) EventLevel {
+	if state == BreakerOpen {
+		return LevelWarn
+	}
+	return LevelInfo
+}
+
+// batchLen to return how many payloads from the head of the partition fit in one batch
+// and their size. A partition held by an open Breaker can outgrow MaxSize and MaxBytes.
+func (q *TypedQueue[T]) batchLen(h *held, pt *partition[T]) (int, int) {
+	if len(pt.payloads) <= q.MaxSize && (q.MaxBytes == 0 || pt.bytes <= q.MaxBytes) {
+		return len(pt.payloads), pt.bytes
+	}
+	n, bytes := 0, 0
+	for n < len(pt.payloads) && n < q.MaxSize {
+		size := q.size(h, pt.payloads[n].Data)
+		if n > 0 && q.MaxBytes > 0 && bytes+size > q.MaxBytes {
+			break
+		}
+		n++
+		bytes += size
+	}
+	return n, bytes
+}
+
+// allow to consult the Breaker, if any, before Work is called. The state change is
+// returned for breakerChanged, since runNext holds payloadMutex at this point.
+func (q *TypedRateQueue[T]) allow() (bool, *BreakerState) {
+	if q.Breaker == nil {
+		return true, nil
+	}
+	return q.Breaker.allow()
+}
+
+// record to count the outcome of a Work call in the Breaker, if any
+func (q *TypedRateQueue[T]) record(success bool) {
+	if q.Breaker != nil {
+		q.breakerChanged(q.Breaker.record(success))
+	}
+}
+
+func (q *TypedRateQueue[T]) breakerChanged(state *BreakerState) {
+	if state != nil {
+		q.event(breakerEvent(*state))
+	}
+}
+
+// breakerReady to check if the Breaker, if any, lets a Work call through. While it is
+// open, the time left until the next trial is returned.
+func (q *TypedRateQueue[T]) breakerReady() (bool, time.Duration) {
+	if q.Breaker == nil {
+		return true, 0
+	}
+	return q.Breaker.ready()
+}
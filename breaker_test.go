@@ -0,0 +1,190 @@
+package payloadqueue_test
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sam-ish/payloadqueue"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	t.Run("Opens on the failure ratio and closes after a trial", func(t *testing.T) {
+		b := &payloadqueue.CircuitBreaker{MinRequests: 4, CoolDown: 50 * time.Millisecond}
+		b.Record(true)
+		b.Record(false)
+		b.Record(true)
+		if b.State() != payloadqueue.BreakerClosed {
+			t.Errorf("Expected the breaker to stay closed below MinRequests, got %s", b.State())
+		}
+		b.Record(false)
+		if b.State() != payloadqueue.BreakerOpen || b.Allow() {
+			t.Errorf("Expected the breaker to be open at a ratio of 0.5, got %s", b.State())
+		}
+		time.Sleep(60 * time.Millisecond)
+		if b.State() != payloadqueue.BreakerHalfOpen {
+			t.Errorf("Expected the breaker to be half-open after CoolDown, got %s", b.State())
+		}
+		if !b.Allow() || b.Allow() {
+			t.Errorf("Expected a single trial call while half-open")
+		}
+		b.Record(true)
+		if b.State() != payloadqueue.BreakerClosed {
+			t.Errorf("Expected the breaker to close after a successful trial, got %s", b.State())
+		}
+	})
+
+	t.Run("A failed trial opens the breaker again", func(t *testing.T) {
+		b := &payloadqueue.CircuitBreaker{MinRequests: 1, CoolDown: 20 * time.Millisecond}
+		b.Record(false)
+		time.Sleep(30 * time.Millisecond)
+		b.Allow()
+		b.Record(false)
+		if b.State() != payloadqueue.BreakerOpen {
+			t.Errorf("Expected the breaker to open after a failed trial, got %s", b.State())
+		}
+	})
+
+	t.Run("Old results fall out of the Window", func(t *testing.T) {
+		b := &payloadqueue.CircuitBreaker{MinRequests: 2, Window: 20 * time.Millisecond}
+		b.Record(false)
+		time.Sleep(30 * time.Millisecond)
+		b.Record(false)
+		if b.State() != payloadqueue.BreakerClosed {
+			t.Errorf("Expected the breaker to stay closed, got %s", b.State())
+		}
+	})
+}
+
+func TestQueueBreaker(t *testing.T) {
+	var runMutex sync.Mutex
+	var worked []string
+	var transitions []string
+	q := &payloadqueue.Queue{
+		MaxSize: 1,
+		MaxAge:  10,
+		Tag:     "QueueBreaker",
+		Breaker: &payloadqueue.CircuitBreaker{MinRequests: 1, CoolDown: 100 * time.Millisecond},
+		Work: func(pls []interface{}) int {
+			runMutex.Lock()
+			defer runMutex.Unlock()
+			if pls[0] == "a" {
+				return 1
+			}
+			worked = append(worked, pls[0].(string))
+			return 0
+		},
+		EventFeed: func(s string) {
+			if i := strings.Index(s, "Circuit Breaker: "); i >= 0 {
+				runMutex.Lock()
+				transitions = append(transitions, s[i+len("Circuit Breaker: "):])
+				runMutex.Unlock()
+			}
+		},
+	}
+	// the held and half-open events are emitted by Append and can call back into the queue
+	q.Events = func(payloadqueue.Event) { q.Size() }
+	q.Start()
+	q.Append(payloadqueue.Payload{Id: "a", Data: "a"})
+	time.Sleep(20 * time.Millisecond)
+	q.Append(payloadqueue.Payload{Id: "b", Data: "b"})
+	q.Append(payloadqueue.Payload{Id: "c", Data: "c"})
+	if q.Size() != 2 {
+		t.Errorf("Expected 2 payloads held by the open breaker, got %d", q.Size())
+	}
+
+	time.Sleep(120 * time.Millisecond)
+	// the half-open breaker lets a single trial batch through
+	q.Append(payloadqueue.Payload{})
+	time.Sleep(20 * time.Millisecond)
+	if q.Size() != 1 {
+		t.Errorf("Expected 1 payload left after the trial, got %d", q.Size())
+	}
+	q.Append(payloadqueue.Payload{})
+	time.Sleep(20 * time.Millisecond)
+	q.Close()
+
+	runMutex.Lock()
+	defer runMutex.Unlock()
+	if len(worked) != 2 {
+		t.Errorf("Expected the held payloads to be sent one per batch, got %v", worked)
+	}
+	if got := strings.Join(transitions, ","); got != "open,half-open,closed" {
+		t.Errorf("Expected the transitions open,half-open,closed, got %s", got)
+	}
+}
+
+func TestQueueBreakerHalfOpen(t *testing.T) {
+	var runs int32
+	q := &payloadqueue.Queue{
+		MaxSize: 1,
+		MaxAge:  10,
+		Tag:     "QueueBreakerHalfOpen",
+		Breaker: &payloadqueue.CircuitBreaker{MinRequests: 1, CoolDown: 50 * time.Millisecond},
+		Work: func(pls []interface{}) int {
+			if atomic.AddInt32(&runs, 1) == 1 {
+				return 1
+			}
+			return 0
+		},
+	}
+	q.Start()
+	q.Append(payloadqueue.Payload{Id: "a", Data: "a"})
+	time.Sleep(20 * time.Millisecond)
+	for i := 0; i < 10; i++ {
+		q.Append(payloadqueue.Payload{Id: strconv.Itoa(i), Data: i})
+	}
+	time.Sleep(60 * time.Millisecond)
+	q.Append(payloadqueue.Payload{Id: "b", Data: "b"})
+	time.Sleep(20 * time.Millisecond)
+	if n := atomic.LoadInt32(&runs); n != 2 {
+		t.Errorf("Expected a single trial batch while half-open, got %d Work calls", n-1)
+	}
+	if q.Size() != 10 {
+		t.Errorf("Expected the other batches to stay buffered, got %d", q.Size())
+	}
+	q.Close()
+}
+
+func TestRateQBreaker(t *testing.T) {
+	var runMutex sync.Mutex
+	worked := 0
+	q := &payloadqueue.RateQueue{
+		Rate:    100,
+		Tag:     "RateQueueBreaker",
+		Breaker: &payloadqueue.CircuitBreaker{MinRequests: 1, CoolDown: 200 * time.Millisecond},
+		Work: func(pl interface{}) int {
+			runMutex.Lock()
+			defer runMutex.Unlock()
+			worked++
+			if pl == 0 {
+				return 1
+			}
+			return 0
+		},
+	}
+	q.Start()
+	for i := 0; i < 3; i++ {
+		q.Append(payloadqueue.Payload{Id: strconv.Itoa(i), Data: i})
+	}
+	time.Sleep(100 * time.Millisecond)
+	runMutex.Lock()
+	if worked != 1 {
+		t.Errorf("Expected 1 Work call before the breaker opened, got %d", worked)
+	}
+	runMutex.Unlock()
+	if q.Size() != 2 {
+		t.Errorf("Expected 2 payloads held by the open breaker, got %d", q.Size())
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	runMutex.Lock()
+	if worked != 3 {
+		t.Errorf("Expected the held payloads to run after CoolDown, got %d Work calls", worked)
+	}
+	runMutex.Unlock()
+	q.Close()
+}
@@ -0,0 +1,64 @@
+package payloadqueue
+
+import "time"
+
+// Clock is the source of time of the queues. The default is the system clock; the
+// clocktest subpackage has a fake Clock that tests can advance by hand.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	NewTicker(d time.Duration) Ticker
+	AfterFunc(d time.Duration, f func()) Timer // calls f in its own goroutine once d has elapsed
+}
+
+// Timer is a time.Timer of a Clock. C is nil for the timers of AfterFunc.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Ticker is a time.Ticker of a Clock
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// systemClock is the Clock used when none is supplied
+type systemClock struct{}
+
+type systemTimer struct{ *time.Timer }
+
+type systemTicker struct{ *time.Ticker }
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+func (systemClock) NewTimer(d time.Duration) Timer { return systemTimer{time.NewTimer(d)} }
+
+func (systemClock) NewTicker(d time.Duration) Ticker { return systemTicker{time.NewTicker(d)} }
+
+func (systemClock) AfterFunc(d time.Duration, f func()) Timer {
+	return systemTimer{time.AfterFunc(d, f)}
+}
+
+func (t systemTimer) C() <-chan time.Time { return t.Timer.C }
+
+func (t systemTicker) C() <-chan time.Time { return t.Ticker.C }
+
+// clockOr to return c, or the system clock when c is nil
+func clockOr(c Clock) Clock {
+	if c == nil {
+		return systemClock{}
+	}
+	return c
+}
+
+// clock to return the Clock of the queue
+func (q *TypedQueue[T]) clock() Clock {
+	return clockOr(q.Clock)
+}
+
+// clock to return the Clock of the queue
+func (q *TypedRateQueue[T]) clock() Clock {
+	return clockOr(q.Clock)
+}
@@ -0,0 +1,87 @@
+package payloadqueue_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sam-ish/payloadqueue"
+	"github.com/sam-ish/payloadqueue/clocktest"
+)
+
+// worked to wait for a value from a Work call, failing the test if none comes
+func worked(t *testing.T, c <-chan int) int {
+	t.Helper()
+	select {
+	case n := <-c:
+		return n
+	case <-time.After(time.Second):
+		t.Fatalf("Expected a Work call")
+	}
+	return 0
+}
+
+// idle to check that no Work call is made
+func idle(t *testing.T, c <-chan int) {
+	t.Helper()
+	select {
+	case n := <-c:
+		t.Errorf("Expected no Work call, got %d", n)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+func TestQueueClock(t *testing.T) {
+	clock := clocktest.NewClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	batches := make(chan int, 1)
+	q := &payloadqueue.Queue{
+		MaxSize: 10,
+		MaxAge:  3,
+		Tag:     "QueueClock",
+		Clock:   clock,
+		Work: func(pls []interface{}) int {
+			batches <- len(pls)
+			return 0
+		},
+	}
+	q.Start()
+	defer q.Close()
+	q.Append(payloadqueue.Payload{Id: "1", Data: 1})
+	q.Append(payloadqueue.Payload{Id: "2", Data: 2})
+
+	// the age is checked every 2 seconds
+	clock.Advance(2 * time.Second)
+	idle(t, batches)
+	clock.Advance(2 * time.Second)
+	if n := worked(t, batches); n != 2 {
+		t.Errorf("Expected a batch of 2 once MaxAge expired, got %d", n)
+	}
+}
+
+func TestRateQClock(t *testing.T) {
+	clock := clocktest.NewClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	pushed := make(chan int, 2)
+	q := &payloadqueue.RateQueue{
+		Rate:           1,
+		Tag:            "RateQueueClock",
+		Clock:          clock,
+		DiscardOnClose: true,
+		Work: func(pl interface{}) int {
+			pushed <- pl.(int)
+			return 0
+		},
+	}
+	q.Start()
+	defer q.Close()
+	q.Append(payloadqueue.Payload{Id: "1", Data: 1})
+	q.Append(payloadqueue.Payload{Id: "2", Data: 2})
+
+	for i := 1; i <= 2; i++ {
+		clock.BlockUntil(1)
+		clock.Advance(999 * time.Millisecond)
+		idle(t, pushed)
+		clock.Advance(time.Millisecond)
+		if got := worked(t, pushed); got != i {
+			t.Errorf("Expected payload %d to be dispatched, got %d", i, got)
+		}
+	}
+}
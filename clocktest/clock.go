@@ -0,0 +1,221 @@
+// Package clocktest has a fake payloadqueue Clock for tests. Time only moves when
+// Advance or Set is called, so tests can assert exactly when batches flush and
+// payloads are dispatched without sleeping.
+package clocktest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sam-ish/payloadqueue"
+)
+
+// Clock is a fake payloadqueue.Clock. The zero value is not usable; use NewClock.
+type Clock struct {
+	mutex   sync.Mutex
+	changed *sync.Cond // broadcast when a waiter is added
+	now     time.Time
+	waiters []*waiter
+}
+
+var _ payloadqueue.Clock = (*Clock)(nil)
+
+// waiter is a timer, a ticker or an AfterFunc of the fake clock
+type waiter struct {
+	clock  *Clock
+	at     time.Time
+	period time.Duration // set for tickers
+	c      chan time.Time
+	f      func()
+	active bool
+}
+
+// NewClock to create a fake clock set at now
+func NewClock(now time.Time) *Clock {
+	c := &Clock{now: now}
+	c.changed = sync.NewCond(&c.mutex)
+	return c
+}
+
+// Now implements payloadqueue.Clock
+func (c *Clock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+// NewTimer implements payloadqueue.Clock
+func (c *Clock) NewTimer(d time.Duration) payloadqueue.Timer {
+	return c.add(&waiter{clock: c, c: make(chan time.Time, 1)}, d)
+}
+
+// NewTicker implements payloadqueue.Clock
+func (c *Clock) NewTicker(d time.Duration) payloadqueue.Ticker {
+	if d <= 0 {
+		panic("clocktest: non-positive interval for NewTicker")
+	}
+	return ticker{c.add(&waiter{clock: c, c: make(chan time.Time, 1), period: d}, d)}
+}
+
+// AfterFunc implements payloadqueue.Clock. f is called by Advance or Set, in its
+// own goroutine like time.AfterFunc.
+func (c *Clock) AfterFunc(d time.Duration, f func()) payloadqueue.Timer {
+	return c.add(&waiter{clock: c, f: f}, d)
+}
+
+// add to register the waiter to fire after d
+func (c *Clock) add(w *waiter, d time.Duration) *waiter {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	w.at = c.now.Add(d)
+	w.active = true
+	c.waiters = append(c.waiters, w)
+	c.changed.Broadcast()
+	if d <= 0 {
+		// already due, like a time.Timer of a negative duration
+		w.fire(c.now)
+	}
+	return w
+}
+
+// Advance to move the clock forward by d, firing the timers, tickers and AfterFuncs
+// that are due in order
+func (c *Clock) Advance(d time.Duration) {
+	c.Set(c.Now().Add(d))
+}
+
+// Set to move the clock to t, firing the timers, tickers and AfterFuncs due by then.
+// The clock never goes back.
+func (c *Clock) Set(t time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for {
+		var next *waiter
+		for _, w := range c.waiters {
+			if w.active && !w.at.After(t) && (next == nil || w.at.Before(next.at)) {
+				next = w
+			}
+		}
+		if next == nil {
+			break
+		}
+		if next.at.After(c.now) {
+			c.now = next.at
+		}
+		next.fire(c.now)
+	}
+	if t.After(c.now) {
+		c.now = t
+	}
+	c.prune()
+}
+
+// Waiters to return the number of timers, tickers and AfterFuncs not fired or stopped
+func (c *Clock) Waiters() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.active()
+}
+
+// BlockUntil to wait until n timers, tickers or AfterFuncs are pending, so that the
+// next Advance reaches them
+func (c *Clock) BlockUntil(n int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for c.active() < n {
+		c.changed.Wait()
+	}
+}
+
+// active to count the pending waiters. Must be called with mutex held.
+func (c *Clock) active() int {
+	n := 0
+	for _, w := range c.waiters {
+		if w.active {
+			n++
+		}
+	}
+	return n
+}
+
+// listed to check if the waiter is still in waiters. Must be called with mutex held.
+func (c *Clock) listed(w *waiter) bool {
+	for _, l := range c.waiters {
+		if l == w {
+			return true
+		}
+	}
+	return false
+}
+
+// prune to drop the waiters that are no longer active. Must be called with mutex held.
+func (c *Clock) prune() {
+	waiters := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.active {
+			waiters = append(waiters, w)
+		}
+	}
+	c.waiters = waiters
+}
+
+// fire to deliver the tick, or start f, and set the next tick of a ticker.
+// Must be called with the clock mutex held.
+func (w *waiter) fire(now time.Time) {
+	if w.period > 0 {
+		w.at = w.at.Add(w.period)
+	} else {
+		w.active = false
+	}
+	if w.f != nil {
+		go w.f()
+		return
+	}
+	select {
+	case w.c <- now:
+	default:
+		// the previous tick was not received, like time.Ticker
+	}
+}
+
+// ticker is the payloadqueue.Ticker of a waiter
+type ticker struct{ *waiter }
+
+// Stop implements payloadqueue.Ticker
+func (t ticker) Stop() {
+	t.waiter.Stop()
+}
+
+// C implements payloadqueue.Timer and payloadqueue.Ticker
+func (w *waiter) C() <-chan time.Time {
+	return w.c
+}
+
+// Stop implements payloadqueue.Timer
+func (w *waiter) Stop() bool {
+	w.clock.mutex.Lock()
+	defer w.clock.mutex.Unlock()
+	active := w.active
+	w.active = false
+	return active
+}
+
+// Reset implements payloadqueue.Timer
+func (w *waiter) Reset(d time.Duration) bool {
+	w.clock.mutex.Lock()
+	defer w.clock.mutex.Unlock()
+	active := w.active
+	w.at = w.clock.now.Add(d)
+	w.active = true
+	if !active {
+		if !w.clock.listed(w) {
+			w.clock.waiters = append(w.clock.waiters, w)
+		}
+		w.clock.changed.Broadcast()
+	}
+	if d <= 0 {
+		// already due, like add
+		w.fire(w.clock.now)
+	}
+	return active
+}
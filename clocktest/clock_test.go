@@ -0,0 +1,98 @@
+package clocktest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sam-ish/payloadqueue/clocktest"
+)
+
+var epoch = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// fired to check if a tick is waiting on c
+func fired(c <-chan time.Time) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestClock(t *testing.T) {
+	t.Run("Timers fire once Advance reaches them", func(t *testing.T) {
+		c := clocktest.NewClock(epoch)
+		timer := c.NewTimer(time.Second)
+		c.Advance(999 * time.Millisecond)
+		if fired(timer.C()) {
+			t.Errorf("Expected the timer not to fire early")
+		}
+		c.Advance(time.Millisecond)
+		if !fired(timer.C()) {
+			t.Errorf("Expected the timer to fire at 1s")
+		}
+		if c.Waiters() != 0 {
+			t.Errorf("Expected no waiters once fired, got %d", c.Waiters())
+		}
+		if !c.Now().Equal(epoch.Add(time.Second)) {
+			t.Errorf("Expected the clock at 1s, got %s", c.Now())
+		}
+	})
+
+	t.Run("Stopped timers do not fire and Reset sets them again", func(t *testing.T) {
+		c := clocktest.NewClock(epoch)
+		timer := c.NewTimer(time.Second)
+		if !timer.Stop() {
+			t.Errorf("Expected Stop to report an active timer")
+		}
+		c.Advance(time.Second)
+		if fired(timer.C()) {
+			t.Errorf("Expected a stopped timer not to fire")
+		}
+		timer.Reset(time.Second)
+		c.Advance(time.Second)
+		if !fired(timer.C()) {
+			t.Errorf("Expected the reset timer to fire")
+		}
+	})
+
+	t.Run("Reset fires at once when already due", func(t *testing.T) {
+		c := clocktest.NewClock(epoch)
+		timer := c.NewTimer(time.Second)
+		timer.Reset(0)
+		if !fired(timer.C()) {
+			t.Errorf("Expected a timer reset to 0 to fire without Advance")
+		}
+		if c.Waiters() != 0 {
+			t.Errorf("Expected no waiters once fired, got %d", c.Waiters())
+		}
+	})
+
+	t.Run("Tickers tick every period", func(t *testing.T) {
+		c := clocktest.NewClock(epoch)
+		ticker := c.NewTicker(time.Second)
+		defer ticker.Stop()
+		for i := 0; i < 3; i++ {
+			c.Advance(time.Second)
+			if !fired(ticker.C()) {
+				t.Errorf("Expected tick %d", i)
+			}
+		}
+	})
+
+	t.Run("AfterFunc calls f once due", func(t *testing.T) {
+		c := clocktest.NewClock(epoch)
+		called := make(chan time.Time, 1)
+		c.AfterFunc(time.Minute, func() { called <- c.Now() })
+		c.BlockUntil(1)
+		c.Advance(time.Hour)
+		select {
+		case at := <-called:
+			if at.Before(epoch.Add(time.Minute)) {
+				t.Errorf("Expected f to be called after a minute, got %s", at)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("Expected f to be called")
+		}
+	})
+}
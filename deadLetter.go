@@ -0,0 +1,173 @@
+package payloadqueue
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// TypedDeadLetter receives the payloads that failed permanently or exhausted their
+// RetryPolicy. Drain returns and removes everything held so it can be redriven.
+type TypedDeadLetter[T any] interface {
+	Add(TypedPayload[T]) error
+	Drain() ([]TypedPayload[T], error)
+}
+
+// DeadLetter is the interface{}-based TypedDeadLetter used by Queue and RateQueue.
+type DeadLetter = TypedDeadLetter[interface{}]
+
+// appender is satisfied by both queue types.
+type appender[T any] interface {
+	Append(TypedPayload[T]) error
+}
+
+// MemoryDeadLetter holds the dead-lettered payloads in memory.
+type MemoryDeadLetter[T any] struct {
+	payloadMutex sync.Mutex
+	payloads     []TypedPayload[T]
+}
+
+// Add to hold the failed payload.
+func (d *MemoryDeadLetter[T]) Add(p TypedPayload[T]) error {
+	d.payloadMutex.Lock()
+	d.payloads = append(d.payloads, p)
+	d.payloadMutex.Unlock()
+	return nil
+}
+
+// Payloads to return a copy of the dead-lettered payloads without removing them.
+func (d *MemoryDeadLetter[T]) Payloads() []TypedPayload[T] {
+	d.payloadMutex.Lock()
+	defer d.payloadMutex.Unlock()
+	return append([]TypedPayload[T](nil), d.payloads...)
+}
+
+// Drain to return and remove all the dead-lettered payloads.
+func (d *MemoryDeadLetter[T]) Drain() ([]TypedPayload[T], error) {
+	d.payloadMutex.Lock()
+	defer d.payloadMutex.Unlock()
+	pls := d.payloads
+	d.payloads = nil
+	return pls, nil
+}
+
+// Size to return the number of dead-lettered payloads.
+func (d *MemoryDeadLetter[T]) Size() int {
+	d.payloadMutex.Lock()
+	defer d.payloadMutex.Unlock()
+	return len(d.payloads)
+}
+
+// FileDeadLetter appends the dead-lettered payloads to Path as JSON lines, so Data
+// must be JSON encodable.
+type FileDeadLetter[T any] struct {
+	Path      string
+	fileMutex sync.Mutex
+}
+
+// Add to append the failed payload to the file.
+func (d *FileDeadLetter[T]) Add(p TypedPayload[T]) error {
+	if d.Path == "" {
+		return errors.New("the dead letter Path is not supplied")
+	}
+	b, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	d.fileMutex.Lock()
+	defer d.fileMutex.Unlock()
+	f, err := os.OpenFile(d.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Payloads to read the dead-lettered payloads without removing them.
+func (d *FileDeadLetter[T]) Payloads() ([]TypedPayload[T], error) {
+	d.fileMutex.Lock()
+	defer d.fileMutex.Unlock()
+	return d.read()
+}
+
+// Drain to read and remove all the dead-lettered payloads. The file is truncated.
+func (d *FileDeadLetter[T]) Drain() ([]TypedPayload[T], error) {
+	d.fileMutex.Lock()
+	defer d.fileMutex.Unlock()
+	pls, err := d.read()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Truncate(d.Path, 0); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return pls, nil
+}
+
+// read to decode every line in the file
+func (d *FileDeadLetter[T]) read() ([]TypedPayload[T], error) {
+	f, err := os.Open(d.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var pls []TypedPayload[T]
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var p TypedPayload[T]
+		if err := json.Unmarshal(scanner.Bytes(), &p); err != nil {
+			return nil, err
+		}
+		pls = append(pls, p)
+	}
+	return pls, scanner.Err()
+}
+
+// redrive to drain the dead letter and append its payloads to q. The attempt count is
+// reset so the queue's RetryPolicy applies afresh. Payloads the queue refuses are put
+// back into the dead letter unchanged. The ones the dead letter cannot take back are
+// lost, and the returned error names them.
+func redrive[T any](d TypedDeadLetter[T], q appender[T]) (int, error) {
+	if d == nil {
+		return 0, errors.New("no dead letter is supplied")
+	}
+	pls, err := d.Drain()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	var failed, lost error
+	var lostIds []string
+	for _, p := range pls {
+		retry := p
+		retry.Attempts = 0
+		if err := q.Append(retry); err != nil {
+			failed = err
+			if err := d.Add(p); err != nil {
+				lost = err
+				lostIds = append(lostIds, p.Id)
+			}
+			continue
+		}
+		n++
+	}
+	if lost != nil {
+		return n, fmt.Errorf("payload(s) %s refused by the queue are lost by the dead letter: %w", strings.Join(lostIds, ", "), lost)
+	}
+	return n, failed
+}
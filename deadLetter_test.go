@@ -0,0 +1,174 @@
+package payloadqueue_test
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sam-ish/payloadqueue"
+)
+
+func TestDeadLetter(t *testing.T) {
+	t.Run("Queue sends exhausted payloads to the DeadLetter", func(t *testing.T) {
+		dl := &payloadqueue.MemoryDeadLetter[interface{}]{}
+		q := &payloadqueue.Queue{
+			Tag:        "QueueDeadLetter",
+			Retry:      payloadqueue.ExponentialBackoff{MaxAttempts: 1},
+			DeadLetter: dl,
+			Work:       func(pls []interface{}) int { return 7 },
+		}
+		q.Run([]payloadqueue.Payload{{Id: "1", Data: "a"}, {Id: "2", Data: "b"}})
+
+		pls := dl.Payloads()
+		if len(pls) != 2 {
+			t.Fatalf("Expected 2 dead-lettered payloads, got %d", len(pls))
+		}
+		if pls[0].Id != "1" || pls[0].Data != "a" || pls[0].Attempts != 1 || pls[0].Result != 7 {
+			t.Errorf("Unexpected dead-lettered payload %+v", pls[0])
+		}
+		if pls[0].LastAttempt.IsZero() {
+			t.Errorf("Expected LastAttempt to be set")
+		}
+	})
+
+	t.Run("RateQueue sends permanent failures to the DeadLetter", func(t *testing.T) {
+		var runMutex sync.Mutex
+		runtimes := 0
+		dl := &payloadqueue.MemoryDeadLetter[interface{}]{}
+		q := &payloadqueue.RateQueue{
+			RequestsPerSecond: 20,
+			Tag:               "RateQueueDeadLetter",
+			Retry:             payloadqueue.ExponentialBackoff{Retryable: payloadqueue.RetryCodes(503)},
+			DeadLetter:        dl,
+			Work: func(pl interface{}) int {
+				runMutex.Lock()
+				runtimes += 1
+				runMutex.Unlock()
+				return 400
+			},
+		}
+		q.Start()
+		q.Append(payloadqueue.Payload{Id: "1"})
+		time.Sleep(300 * time.Millisecond)
+		q.Close()
+
+		runMutex.Lock()
+		if runtimes != 1 {
+			t.Errorf("Expected runtimes to be 1, got %d", runtimes)
+		}
+		runMutex.Unlock()
+		if dl.Size() != 1 {
+			t.Fatalf("Expected 1 dead-lettered payload, got %d", dl.Size())
+		}
+		if p := dl.Payloads()[0]; p.Result != 400 || p.Queued.IsZero() {
+			t.Errorf("Unexpected dead-lettered payload %+v", p)
+		}
+	})
+
+	t.Run("Redrive appends the payloads back into the queue", func(t *testing.T) {
+		dl := &payloadqueue.MemoryDeadLetter[interface{}]{}
+		dl.Add(payloadqueue.Payload{Id: "1", Attempts: 3})
+		dl.Add(payloadqueue.Payload{Id: "2", Attempts: 3})
+		dl.Add(payloadqueue.Payload{Id: "3", Attempts: 3})
+		q := &payloadqueue.RateQueue{
+			MaxSize: 2,
+			Tag:     "RateQueueRedrive",
+			Work:    func(pl interface{}) int { return 0 },
+		}
+		n, err := q.Redrive(dl)
+		if n != 2 || err == nil {
+			t.Errorf("Expected 2 payloads redriven and a full queue error, got %d and %v", n, err)
+		}
+		if q.Size() != 2 {
+			t.Errorf("Expected q.Size() to be 2, got %d", q.Size())
+		}
+		if pls := dl.Payloads(); len(pls) != 1 || pls[0].Id != "3" || pls[0].Attempts != 3 {
+			t.Errorf("Expected payload 3 to stay in the dead letter, got %+v", pls)
+		}
+	})
+}
+
+func TestFileDeadLetter(t *testing.T) {
+	type job struct {
+		Name string `json:"name"`
+	}
+
+	t.Run("Payloads are appended and drained", func(t *testing.T) {
+		dl := &payloadqueue.FileDeadLetter[job]{Path: filepath.Join(t.TempDir(), "dead.jsonl")}
+		if pls, err := dl.Payloads(); err != nil || len(pls) != 0 {
+			t.Errorf("Expected an empty dead letter, got %d and %v", len(pls), err)
+		}
+		dl.Add(payloadqueue.TypedPayload[job]{Id: "1", Data: job{Name: "Alpha"}, Attempts: 2, Result: 5})
+		dl.Add(payloadqueue.TypedPayload[job]{Id: "2", Data: job{Name: "Beta"}})
+
+		pls, err := dl.Drain()
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		if len(pls) != 2 || pls[0].Data.Name != "Alpha" || pls[0].Attempts != 2 || pls[0].Result != 5 {
+			t.Errorf("Unexpected payloads %+v", pls)
+		}
+		if pls, _ := dl.Payloads(); len(pls) != 0 {
+			t.Errorf("Expected the dead letter to be empty after Drain, got %d", len(pls))
+		}
+	})
+
+	t.Run("Redrive into a TypedQueue", func(t *testing.T) {
+		dl := &payloadqueue.FileDeadLetter[job]{Path: filepath.Join(t.TempDir(), "dead.jsonl")}
+		dl.Add(payloadqueue.TypedPayload[job]{Id: "1", Data: job{Name: "Alpha"}, Attempts: 3})
+
+		var runMutex sync.Mutex
+		var got []job
+		q := &payloadqueue.TypedQueue[job]{
+			MaxSize: 1,
+			Tag:     "QueueRedrive",
+			Work: func(jobs []job) int {
+				runMutex.Lock()
+				got = append(got, jobs...)
+				runMutex.Unlock()
+				return 0
+			},
+		}
+		q.Start()
+		if n, err := q.Redrive(dl); n != 1 || err != nil {
+			t.Errorf("Expected 1 payload redriven, got %d and %v", n, err)
+		}
+		q.Close()
+		runMutex.Lock()
+		if len(got) != 1 || got[0].Name != "Alpha" {
+			t.Errorf("Expected Alpha to be redriven, got %+v", got)
+		}
+		runMutex.Unlock()
+	})
+
+	t.Run("Redrive reports the payloads the dead letter cannot take back", func(t *testing.T) {
+		dl := &drainOnly{pls: []payloadqueue.Payload{{Id: "1", Data: "a"}}}
+		q := &payloadqueue.Queue{Tag: "QueueRedrive", Work: func(pls []interface{}) int { return 0 }}
+		q.Start()
+		q.Close()
+		n, err := q.Redrive(dl)
+		if n != 0 || err == nil || !strings.Contains(err.Error(), "payload(s) 1 ") || !errors.Is(err, errFull) {
+			t.Errorf("Expected the lost payload to be reported, got %d and %v", n, err)
+		}
+	})
+}
+
+var errFull = errors.New("the dead letter is full")
+
+// drainOnly is a DeadLetter that hands out its payloads once and refuses any Add
+type drainOnly struct {
+	pls []payloadqueue.Payload
+}
+
+func (d *drainOnly) Add(payloadqueue.Payload) error {
+	return errFull
+}
+
+func (d *drainOnly) Drain() ([]payloadqueue.Payload, error) {
+	pls := d.pls
+	d.pls = nil
+	return pls, nil
+}
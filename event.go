@@ -0,0 +1,99 @@
+package payloadqueue
+
+import "time"
+
+// EventKind tells what happened in a queue
+type EventKind int
+
+const (
+	EventDefault       EventKind = iota // a default value was applied by Start
+	EventStarted                        // the queue was started
+	EventStopping                       // Close was called
+	EventStopped                        // all Work has completed or the shutdown deadline passed
+	EventQueued                         // a payload was appended
+	EventScheduled                      // a payload was appended with a NotBefore in the future
+	EventUnscheduled                    // scheduled payloads not due were kept or dead-lettered on Close
+	EventAppendFailed                   // a payload was refused by Append
+	EventOversized                      // a payload above MaxBytes was sent alone
+	EventBatchRunning                   // Work was called with a batch
+	EventBatchFinished                  // Work returned for a batch
+	EventBatchBuffered                  // a batch is waiting for a worker
+	EventBatchRejected                  // a batch was rejected because all workers are busy
+	EventBatchHeld                      // the payloads are held by an open Breaker
+	EventPushed                         // RateQueue Work returned for a payload
+	EventRetry                          // a failed payload will be appended again
+	EventFailed                         // a failed payload will not be retried
+	EventDeadLetter                     // a payload was handed to the DeadLetter
+	EventWAL                            // the WAL replayed payloads or failed
+	EventEvicted                        // a partition or a key was evicted
+	EventRateChanged                    // the Adaptive rate changed
+	EventHeldBack                       // the RateQueue is held back by a Result RetryAfter
+	EventBreaker                        // the Breaker changed state
+	EventLimiter                        // the Limiter failed
+	EventPending                        // the payloads left to flush on Close
+)
+
+var eventKinds = [...]string{
+	"default", "started", "stopping", "stopped", "queued", "scheduled", "unscheduled",
+	"append_failed", "oversized", "batch_running", "batch_finished", "batch_buffered",
+	"batch_rejected", "batch_held", "pushed", "retry", "failed", "dead_letter", "wal",
+	"evicted", "rate_changed", "held_back", "breaker", "limiter", "pending",
+}
+
+func (k EventKind) String() string {
+	if k < 0 || int(k) >= len(eventKinds) {
+		return "unknown"
+	}
+	return eventKinds[k]
+}
+
+// EventLevel is the severity of an Event. The values match the log/slog levels.
+type EventLevel int
+
+const (
+	LevelDebug EventLevel = -4
+	LevelInfo  EventLevel = 0
+	LevelWarn  EventLevel = 4
+	LevelError EventLevel = 8
+)
+
+func (l EventLevel) String() string {
+	switch {
+	case l < LevelInfo:
+		return "DEBUG"
+	case l < LevelWarn:
+		return "INFO"
+	case l < LevelError:
+		return "WARN"
+	}
+	return "ERROR"
+}
+
+// Event is a typed queue event. Only the fields that apply to its Kind are set.
+type Event struct {
+	Kind     EventKind
+	Level    EventLevel
+	Tag      string
+	Ids      []string      // the payloads involved
+	Size     int           // batch size, or the number of payloads involved
+	Result   int           // result code of the Work call
+	Duration time.Duration // Work duration, retry delay or hold back
+	Err      error
+	Time     time.Time
+	Message  string // the text of the event, as sent to EventFeed
+}
+
+// String to format the event as the string EventFeed receives
+func (e Event) String() string {
+	return "[" + e.Tag + "] " + e.Message
+}
+
+// EventHandler receives the typed events of a queue
+type EventHandler func(Event)
+
+// StringFeed to adapt a string feed, like EventFeed, to an EventHandler
+func StringFeed(feed func(string)) EventHandler {
+	return func(e Event) {
+		feed(e.String())
+	}
+}
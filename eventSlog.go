@@ -0,0 +1,41 @@
+//go:build go1.21
+
+package payloadqueue
+
+import (
+	"context"
+	"log/slog"
+)
+
+// SlogHandler to log the events to logger at their level, with the event fields as attributes
+func SlogHandler(logger *slog.Logger) EventHandler {
+	return func(e Event) {
+		level := slog.Level(e.Level)
+		ctx := context.Background()
+		if !logger.Handler().Enabled(ctx, level) {
+			return
+		}
+		attrs := []slog.Attr{
+			slog.String("kind", e.Kind.String()),
+			slog.String("tag", e.Tag),
+		}
+		if len(e.Ids) > 0 {
+			attrs = append(attrs, slog.Any("ids", e.Ids))
+		}
+		if e.Size != 0 {
+			attrs = append(attrs, slog.Int("size", e.Size))
+		}
+		if e.Result != 0 {
+			attrs = append(attrs, slog.Int("result", e.Result))
+		}
+		if e.Duration != 0 {
+			attrs = append(attrs, slog.Duration("duration", e.Duration))
+		}
+		if e.Err != nil {
+			attrs = append(attrs, slog.String("error", e.Err.Error()))
+		}
+		r := slog.NewRecord(e.Time, level, e.Message, 0)
+		r.AddAttrs(attrs...)
+		logger.Handler().Handle(ctx, r)
+	}
+}
@@ -0,0 +1,31 @@
+//go:build go1.21
+
+package payloadqueue_test
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/sam-ish/payloadqueue"
+)
+
+func TestSlogHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+	events := payloadqueue.SlogHandler(logger)
+
+	events(payloadqueue.Event{Kind: payloadqueue.EventQueued, Level: payloadqueue.LevelDebug, Message: "Payload Queued [id]: a"})
+	events(payloadqueue.Event{Kind: payloadqueue.EventRetry, Level: payloadqueue.LevelWarn, Tag: "Tag", Ids: []string{"b"}, Result: 500, Message: "Payload Retry [id]: b"})
+
+	out := buf.String()
+	if strings.Contains(out, "Payload Queued") {
+		t.Errorf("Expected the debug event to be filtered out, got %s", out)
+	}
+	for _, want := range []string{"level=WARN", `msg="Payload Retry [id]: b"`, "kind=retry", "tag=Tag", "ids=[b]", "result=500"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %s in %s", want, out)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package payloadqueue_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sam-ish/payloadqueue"
+)
+
+func TestEvents(t *testing.T) {
+	t.Run("Typed events describe the batch", func(t *testing.T) {
+		var feedMutex sync.Mutex
+		var finished []payloadqueue.Event
+		var fed, typed []string
+		q := &payloadqueue.Queue{
+			MaxSize: 2,
+			MaxAge:  10,
+			Tag:     "QueueEvents",
+			Work:    func(pls []interface{}) int { return 3 },
+			Events: func(e payloadqueue.Event) {
+				feedMutex.Lock()
+				defer feedMutex.Unlock()
+				if e.Kind == payloadqueue.EventBatchFinished {
+					finished = append(finished, e)
+				}
+				typed = append(typed, e.String())
+			},
+			EventFeed: func(s string) {
+				feedMutex.Lock()
+				fed = append(fed, s)
+				feedMutex.Unlock()
+			},
+		}
+		q.Start()
+		q.Append(payloadqueue.Payload{Id: "a", Data: "a"})
+		q.Append(payloadqueue.Payload{Id: "b", Data: "b"})
+		time.Sleep(50 * time.Millisecond)
+		q.Close()
+
+		feedMutex.Lock()
+		defer feedMutex.Unlock()
+		if len(finished) != 1 {
+			t.Fatalf("Expected 1 batch finished event, got %d", len(finished))
+		}
+		e := finished[0]
+		if e.Tag != "QueueEvents" || e.Size != 2 || e.Result != 3 || len(e.Ids) != 2 || e.Ids[0] != "a" || e.Time.IsZero() {
+			t.Errorf("Unexpected batch finished event: %+v", e)
+		}
+		if len(typed) != len(fed) {
+			t.Fatalf("Expected the string feed to get every event, got %d and %d", len(fed), len(typed))
+		}
+		for i := range typed {
+			if typed[i] != fed[i] {
+				t.Errorf("Expected %q in the string feed, got %q", typed[i], fed[i])
+			}
+		}
+	})
+
+	t.Run("RateQueue events arrive before Close returns", func(t *testing.T) {
+		var feedMutex sync.Mutex
+		var kinds []payloadqueue.EventKind
+		q := &payloadqueue.RateQueue{
+			RequestsPerSecond: 1000,
+			Tag:               "RateQueueEvents",
+			Work:              func(pl interface{}) int { return 0 },
+			Events: func(e payloadqueue.Event) {
+				feedMutex.Lock()
+				kinds = append(kinds, e.Kind)
+				feedMutex.Unlock()
+			},
+		}
+		q.Start()
+		q.Append(payloadqueue.Payload{Id: "a", Data: "a"})
+		q.Close()
+
+		feedMutex.Lock()
+		defer feedMutex.Unlock()
+		pushed := -1
+		for i, k := range kinds {
+			if k == payloadqueue.EventPushed {
+				pushed = i
+			}
+		}
+		if pushed < 0 || kinds[len(kinds)-1] != payloadqueue.EventStopped {
+			t.Errorf("Expected EventPushed before EventStopped, got %v", kinds)
+		}
+	})
+
+	t.Run("StringFeed adapts a string feed", func(t *testing.T) {
+		var got string
+		feed := payloadqueue.StringFeed(func(s string) { got = s })
+		feed(payloadqueue.Event{Tag: "Tag", Message: "Payload Queued [id]: a"})
+		if got != "[Tag] Payload Queued [id]: a" {
+			t.Errorf("Unexpected string: %s", got)
+		}
+		if payloadqueue.EventRetry.String() != "retry" || payloadqueue.LevelWarn.String() != "WARN" {
+			t.Errorf("Unexpected names: %s %s", payloadqueue.EventRetry, payloadqueue.LevelWarn)
+		}
+	})
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"log"
 	"strconv"
@@ -12,7 +11,7 @@ import (
 
 func main() {
 
-	q := plq.Queue{
+	q := plq.TypedQueue[Job]{
 		Tag:       "QueueA",
 		Work:      Datahandler,
 		EventFeed: Print,
@@ -21,7 +20,7 @@ func main() {
 	}
 	q.Start()
 
-	qb := plq.Queue{
+	qb := plq.TypedQueue[Job]{
 		Tag:       "QueueB",
 		Work:      Datahandler,
 		EventFeed: Print,
@@ -43,9 +42,7 @@ func main() {
 
 	go func() {
 		for i := 0; i < 50; i++ {
-			qb.Append(qb.NewPayload(struct {
-				Name string
-			}{
+			qb.Append(qb.NewPayload(Job{
 				Name: "DataB",
 			}),
 			)
@@ -62,11 +59,7 @@ type Job struct {
 	Duration int    `json:"duration"` // milliseconds
 }
 
-func Datahandler(pls []interface{}) int {
-	jobs := make([]Job, 0)
-	bodyBytes, _ := json.Marshal(pls)
-	json.Unmarshal(bodyBytes, &jobs)
-
+func Datahandler(jobs []Job) int {
 	for _, v := range jobs {
 		fmt.Println("Got: " + v.Name)
 	}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"log"
 	"strconv"
@@ -12,7 +11,7 @@ import (
 
 func main() {
 
-	q := plq.RateQueue{
+	q := plq.TypedRateQueue[Job]{
 		Tag:               "RateQueueA",
 		Work:              Datahandler,
 		EventFeed:         Print,
@@ -41,11 +40,7 @@ type Job struct {
 	Duration int    `json:"duration"` // milliseconds
 }
 
-func Datahandler(pl interface{}) int {
-	job := Job{}
-	bodyBytes, _ := json.Marshal(pl)
-	json.Unmarshal(bodyBytes, &job)
-
+func Datahandler(job Job) int {
 	fmt.Println(time.Now().String()+": Got: "+job.Name, job.Duration)
 	return 0
 }
@@ -0,0 +1,125 @@
+package payloadqueue
+
+import (
+	"errors"
+	"time"
+)
+
+// keyQueue holds the payloads of a single RateQueue key within a lane. The keys are
+// served by deficit round-robin so each key gets a fair share of the rate.
+type keyQueue[T any] struct {
+	key      string
+	payloads []TypedPayload[T]
+	deficit  int  // cost the key can still spend in its turn
+	turn     bool // the Quantum of the current turn has been added
+}
+
+// key to return the key of the data
+func (q *TypedRateQueue[T]) key(data T) string {
+	if q.KeyFunc == nil {
+		return ""
+	}
+	return q.KeyFunc(data)
+}
+
+// push to add the payload to its key queue, at the head when front is set
+func (l *lane[T]) push(key string, p TypedPayload[T], front bool) {
+	kq, ok := l.keys[key]
+	if !ok {
+		kq = &keyQueue[T]{key: key}
+		l.keys[key] = kq
+	}
+	if len(kq.payloads) == 0 {
+		l.active = append(l.active, kq)
+	}
+	if front {
+		kq.payloads = append([]TypedPayload[T]{p}, kq.payloads...)
+	} else {
+		kq.payloads = append(kq.payloads, p)
+	}
+	l.size++
+}
+
+// popLane to remove the next payload of the lane by deficit round-robin. Keys held back
+// by their rate limit are skipped without losing their deficit. Must be called with payloadMutex held.
+func (q *TypedRateQueue[T]) popLane(l *lane[T], now time.Time) (TypedPayload[T], bool) {
+	for skipped := 0; len(l.active) > 0 && skipped < len(l.active); {
+		kq := l.active[0]
+		if !q.keyReady(kq.key, now) {
+			l.active = append(l.active[1:], kq)
+			skipped++
+			continue
+		}
+		skipped = 0
+		if !kq.turn {
+			kq.deficit += q.quantum()
+			kq.turn = true
+		}
+		cost := q.cost(kq.payloads[0].Data)
+		if cost > kq.deficit {
+			// the turn is over. The deficit is kept for the next one
+			kq.turn = false
+			l.active = append(l.active[1:], kq)
+			continue
+		}
+		var p TypedPayload[T]
+		p, kq.payloads = kq.payloads[0], kq.payloads[1:]
+		kq.deficit -= cost
+		l.size--
+		if len(kq.payloads) == 0 {
+			l.active = l.active[1:]
+			delete(l.keys, kq.key)
+		}
+		q.takeKey(kq.key, now)
+		return p, true
+	}
+	return TypedPayload[T]{}, false
+}
+
+// runnable to check if the lane has a payload whose key can run now.
+// Must be called with payloadMutex held.
+func (q *TypedRateQueue[T]) runnable(l *lane[T], now time.Time) bool {
+	for _, kq := range l.active {
+		if q.keyReady(kq.key, now) {
+			return true
+		}
+	}
+	return false
+}
+
+// keySize to return the number of payloads of the key in all the lanes.
+// Must be called with payloadMutex held.
+func (q *TypedRateQueue[T]) keySize(key string) int {
+	n := 0
+	for _, l := range q.lanes {
+		if kq, ok := l.keys[key]; ok {
+			n += len(kq.payloads)
+		}
+	}
+	return n
+}
+
+// checkKey to refuse the payload when its key already has KeyMaxSize payloads.
+// Must be called with payloadMutex held.
+func (q *TypedRateQueue[T]) checkKey(p TypedPayload[T], key string) error {
+	if q.KeyMaxSize > 0 && q.keySize(key) >= q.KeyMaxSize {
+		return errors.New("Payload " + p.Id + " failed. Key " + key + " is full. Try again later")
+	}
+	return nil
+}
+
+// quantum to return Quantum or its default of 1
+func (q *TypedRateQueue[T]) quantum() int {
+	if q.Quantum <= 0 {
+		return 1
+	}
+	return q.Quantum
+}
+
+// cost to return the cost of the data in the deficit round-robin. Default is 1
+func (q *TypedRateQueue[T]) cost(data T) int {
+	if q.CostFunc == nil {
+		return 1
+	}
+	return q.CostFunc(data)
+}
@@ -0,0 +1,106 @@
+package payloadqueue_test
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sam-ish/payloadqueue"
+)
+
+func tenant(pl interface{}) string {
+	return strings.TrimRight(pl.(string), "0123456789")
+}
+
+func TestRateQFairScheduling(t *testing.T) {
+	t.Run("Keys take turns", func(t *testing.T) {
+		q := &payloadqueue.RateQueue{KeyFunc: tenant}
+		order, run := laneQueue(t, q)
+		for i := 0; i < 4; i++ {
+			q.Append(payloadqueue.Payload{Id: "n" + strconv.Itoa(i), Data: "noisy" + strconv.Itoa(i)})
+		}
+		q.Append(payloadqueue.Payload{Id: "q0", Data: "quiet0"})
+		q.Append(payloadqueue.Payload{Id: "q1", Data: "quiet1"})
+		run()
+		got := strings.Join(order(), ",")
+		if got != "noisy0,quiet0,noisy1,quiet1,noisy2,noisy3" {
+			t.Errorf("Expected the keys to alternate, got %s", got)
+		}
+		q.Close()
+	})
+
+	t.Run("Deficit round-robin weighs the cost", func(t *testing.T) {
+		q := &payloadqueue.RateQueue{
+			KeyFunc: tenant,
+			Quantum: 2,
+			CostFunc: func(pl interface{}) int {
+				if tenant(pl) == "big" {
+					return 2
+				}
+				return 1
+			},
+		}
+		order, run := laneQueue(t, q)
+		for i := 0; i < 2; i++ {
+			q.Append(payloadqueue.Payload{Id: "b" + strconv.Itoa(i), Data: "big" + strconv.Itoa(i)})
+			q.Append(payloadqueue.Payload{Id: "s" + strconv.Itoa(i), Data: "small" + strconv.Itoa(i)})
+			q.Append(payloadqueue.Payload{Id: "s" + strconv.Itoa(i+2), Data: "small" + strconv.Itoa(i+2)})
+		}
+		run()
+		got := strings.Join(order(), ",")
+		if got != "big0,small0,small2,big1,small1,small3" {
+			t.Errorf("Expected a big item per two small ones, got %s", got)
+		}
+		q.Close()
+	})
+
+	t.Run("KeyMaxSize caps each key", func(t *testing.T) {
+		q := &payloadqueue.RateQueue{KeyFunc: tenant, KeyMaxSize: 2}
+		_, run := laneQueue(t, q)
+		for i := 0; i < 2; i++ {
+			if err := q.Append(payloadqueue.Payload{Id: "n" + strconv.Itoa(i), Data: "noisy" + strconv.Itoa(i)}); err != nil {
+				t.Errorf("Unexpected error: %s", err.Error())
+			}
+		}
+		if err := q.Append(payloadqueue.Payload{Id: "n2", Data: "noisy2"}); err == nil {
+			t.Errorf("Expected the third noisy payload to be refused")
+		}
+		if err := q.Append(payloadqueue.Payload{Id: "q0", Data: "quiet0"}); err != nil {
+			t.Errorf("Unexpected error: %s", err.Error())
+		}
+		run()
+		q.Close()
+	})
+
+	t.Run("KeyRate caps each key", func(t *testing.T) {
+		var runMutex sync.Mutex
+		worked := map[string]int{}
+		q := &payloadqueue.RateQueue{
+			Rate:    1000,
+			KeyRate: 10,
+			KeyFunc: tenant,
+			Tag:     "RateQueueFair",
+			Work: func(pl interface{}) int {
+				runMutex.Lock()
+				worked[tenant(pl)]++
+				runMutex.Unlock()
+				return 0
+			},
+		}
+		q.Start()
+		for i := 0; i < 5; i++ {
+			q.Append(payloadqueue.Payload{Id: "a" + strconv.Itoa(i), Data: "a" + strconv.Itoa(i)})
+			q.Append(payloadqueue.Payload{Id: "b" + strconv.Itoa(i), Data: "b" + strconv.Itoa(i)})
+		}
+		time.Sleep(250 * time.Millisecond)
+		runMutex.Lock()
+		// a token up front and one per 100ms
+		if worked["a"] < 2 || worked["a"] > 3 || worked["b"] < 2 || worked["b"] > 3 {
+			t.Errorf("Expected 2 to 3 Work calls per key, got %v", worked)
+		}
+		runMutex.Unlock()
+		q.Close()
+	})
+}
@@ -0,0 +1,55 @@
+package payloadqueue
+
+import (
+	"context"
+	"sync"
+)
+
+// Flush to dispatch the payloads buffered in every partition now, without waiting for
+// MaxSize or MaxAge. Payloads held by an open Breaker or rejected by OverflowReject
+// stay in the queue and the error is returned.
+func (q *TypedQueue[T]) Flush() error {
+	return q.flushAll(nil, false)
+}
+
+// FlushAndWait to Flush and return once Work has completed for the flushed batches,
+// or with the error of ctx if it is done first. When a partition cannot be flushed, the
+// batches of the others are still waited for before its error is returned. Work failures
+// are handled as usual by the Retry policy and the DeadLetter.
+func (q *TypedQueue[T]) FlushAndWait(ctx context.Context) error {
+	var wait sync.WaitGroup
+	err := q.flushAll(&wait, false)
+	done := make(chan struct{})
+	go func() {
+		wait.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// flushAll to flush every partition. The dispatched batches are added to wait when supplied.
+// CloseContext sets close so that the queue is closed in the same lock as the flush.
+func (q *TypedQueue[T]) flushAll(wait *sync.WaitGroup, close bool) error {
+	var h held
+	q.payloadMutex.Lock()
+	if q.closed {
+		q.payloadMutex.Unlock()
+		return errClosed
+	}
+	q.flushWait = wait
+	var err error
+	for key, pt := range q.partitions {
+		if e := q.flush(&h, key, pt, FlushManual); e != nil && err == nil {
+			err = e
+		}
+	}
+	q.flushWait = nil
+	q.closed = close
+	q.unlock(&h)
+	return err
+}
@@ -0,0 +1,187 @@
+package payloadqueue_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sam-ish/payloadqueue"
+)
+
+func TestQueueFlush(t *testing.T) {
+	t.Run("FlushAndWait returns once Work is done", func(t *testing.T) {
+		var runMutex sync.Mutex
+		worked := 0
+		q := &payloadqueue.Queue{
+			MaxSize: 10,
+			MaxAge:  1000,
+			Tag:     "QueueFlush",
+			KeyFunc: func(pl interface{}) string { return pl.(string) },
+			Work: func(pls []interface{}) int {
+				time.Sleep(20 * time.Millisecond)
+				runMutex.Lock()
+				worked += len(pls)
+				runMutex.Unlock()
+				return 0
+			},
+		}
+		q.Start()
+		defer q.Close()
+		q.Append(payloadqueue.Payload{Id: "1", Data: "a"})
+		q.Append(payloadqueue.Payload{Id: "2", Data: "b"})
+		q.Append(payloadqueue.Payload{Id: "3", Data: "b"})
+		if err := q.FlushAndWait(context.Background()); err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		runMutex.Lock()
+		if worked != 3 {
+			t.Errorf("Expected 3 payloads worked, got %d", worked)
+		}
+		runMutex.Unlock()
+		if q.Size() != 0 {
+			t.Errorf("Expected an empty queue, got %d", q.Size())
+		}
+	})
+
+	t.Run("FlushAndWait waits for the flushed batches when a partition fails", func(t *testing.T) {
+		var runMutex sync.Mutex
+		worked := 0
+		q := &payloadqueue.Queue{
+			MaxSize:              10,
+			MaxAge:               1000,
+			MaxConcurrentBatches: 1,
+			Overflow:             payloadqueue.OverflowReject,
+			Tag:                  "QueueFlush",
+			KeyFunc:              func(pl interface{}) string { return pl.(string) },
+			Work: func(pls []interface{}) int {
+				time.Sleep(50 * time.Millisecond)
+				runMutex.Lock()
+				worked += len(pls)
+				runMutex.Unlock()
+				return 0
+			},
+		}
+		q.Start()
+		defer q.Close()
+		q.Append(payloadqueue.Payload{Id: "1", Data: "a"})
+		q.Append(payloadqueue.Payload{Id: "2", Data: "b"})
+		if err := q.FlushAndWait(context.Background()); err == nil {
+			t.Errorf("Expected the error of the partition rejected by the busy worker")
+		}
+		runMutex.Lock()
+		if worked != 1 {
+			t.Errorf("Expected the flushed batch to be worked before FlushAndWait returns, got %d", worked)
+		}
+		runMutex.Unlock()
+	})
+
+	t.Run("FlushAndWait stops waiting when ctx is done", func(t *testing.T) {
+		release := make(chan struct{})
+		q := &payloadqueue.Queue{
+			MaxSize: 10,
+			MaxAge:  1000,
+			Tag:     "QueueFlush",
+			Work: func(pls []interface{}) int {
+				<-release
+				return 0
+			},
+		}
+		q.Start()
+		q.Append(payloadqueue.Payload{Id: "1", Data: 1})
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		if err := q.FlushAndWait(ctx); err != context.DeadlineExceeded {
+			t.Errorf("Expected the deadline to be exceeded, got %v", err)
+		}
+		close(release)
+		q.Close()
+	})
+
+	t.Run("FlushSignal flushes the buffer", func(t *testing.T) {
+		signal := make(chan struct{})
+		worked := make(chan int, 1)
+		q := &payloadqueue.Queue{
+			MaxSize:     10,
+			MaxAge:      1000,
+			Tag:         "QueueFlush",
+			FlushSignal: signal,
+			Work: func(pls []interface{}) int {
+				worked <- len(pls)
+				return 0
+			},
+		}
+		q.Start()
+		defer q.Close()
+		q.Append(payloadqueue.Payload{Id: "1", Data: 1})
+		q.Append(payloadqueue.Payload{Id: "2", Data: 2})
+		signal <- struct{}{}
+		select {
+		case n := <-worked:
+			if n != 2 {
+				t.Errorf("Expected a batch of 2, got %d", n)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("Expected the signal to flush the buffer")
+		}
+	})
+
+	t.Run("A closed FlushSignal is ignored", func(t *testing.T) {
+		signal := make(chan struct{})
+		close(signal)
+		worked := make(chan int, 1)
+		q := &payloadqueue.Queue{
+			MaxSize:     10,
+			MaxAge:      1000,
+			Tag:         "QueueFlush",
+			FlushSignal: signal,
+			Work: func(pls []interface{}) int {
+				worked <- len(pls)
+				return 0
+			},
+		}
+		q.Start()
+		defer q.Close()
+		q.Append(payloadqueue.Payload{Id: "1", Data: 1})
+		select {
+		case <-worked:
+			t.Errorf("Expected no flush from a closed signal")
+		case <-time.After(50 * time.Millisecond):
+		}
+	})
+
+	t.Run("Close flushes the buffer and the payloads not due", func(t *testing.T) {
+		var runMutex sync.Mutex
+		worked := 0
+		q := &payloadqueue.Queue{
+			MaxSize: 10,
+			MaxAge:  1000,
+			Tag:     "QueueFlush",
+			Work: func(pls []interface{}) int {
+				runMutex.Lock()
+				worked += len(pls)
+				runMutex.Unlock()
+				return 0
+			},
+		}
+		q.Start()
+		q.Append(payloadqueue.Payload{Id: "1", Data: 1})
+		q.Append(payloadqueue.Payload{Id: "2", Data: 2})
+		q.AppendAfter(payloadqueue.Payload{Id: "3", Data: 3}, time.Hour)
+		q.Close()
+		runMutex.Lock()
+		defer runMutex.Unlock()
+		if worked != 3 {
+			t.Errorf("Expected the 3 payloads to be worked on Close, got %d", worked)
+		}
+	})
+
+	t.Run("Flush is refused once closed", func(t *testing.T) {
+		q := &payloadqueue.Queue{Tag: "QueueFlush", Work: func(pls []interface{}) int { return 0 }}
+		q.Start()
+		q.Close()
+		if err := q.Flush(); err == nil {
+			t.Errorf("Expected an error once closed")
+		}
+	})
+}
@@ -0,0 +1,111 @@
+package payloadqueue
+
+import "time"
+
+// keyLimit is the token bucket of a RateQueue key with a rate limit
+type keyLimit struct {
+	bucket   *tokenBucket
+	lastUsed time.Time
+}
+
+// keyRate to return the rate of the key in requests per Per. 0 is no cap
+func (q *TypedRateQueue[T]) keyRate(key string) float64 {
+	if rate, ok := q.KeyRates[key]; ok {
+		return rate
+	}
+	return q.KeyRate
+}
+
+// keyBucket to return the token bucket of the key, creating it full when needed. Nil is
+// returned for a key without a rate limit. Must be called with payloadMutex held.
+func (q *TypedRateQueue[T]) keyBucket(key string, now time.Time) *tokenBucket {
+	if l, ok := q.keyLimits[key]; ok {
+		l.lastUsed = now
+		return l.bucket
+	}
+	rate := q.keyRate(key)
+	if rate <= 0 {
+		return nil
+	}
+	if q.keyLimits == nil {
+		q.keyLimits = make(map[string]*keyLimit)
+	}
+	b := newTokenBucket(rate/q.Per.Seconds(), q.Burst, now)
+	b.tokens = b.burst
+	q.keyLimits[key] = &keyLimit{bucket: b, lastUsed: now}
+	return b
+}
+
+// keyReady to check if the rate limit of the key lets it run now.
+// Must be called with payloadMutex held.
+func (q *TypedRateQueue[T]) keyReady(key string, now time.Time) bool {
+	b := q.keyBucket(key, now)
+	return b == nil || b.wait(now) == 0
+}
+
+// takeKey to consume a token of the key, if it has a rate limit.
+// Must be called with payloadMutex held.
+func (q *TypedRateQueue[T]) takeKey(key string, now time.Time) {
+	if b := q.keyBucket(key, now); b != nil {
+		b.take(now)
+	}
+}
+
+// keyWait to return how long the dispatching waits for a key token when every queued
+// key is held back by its rate limit. Must be called with payloadMutex held.
+func (q *TypedRateQueue[T]) keyWait(now time.Time) time.Duration {
+	if q.KeyRate <= 0 && len(q.KeyRates) == 0 {
+		return 0
+	}
+	var wait time.Duration
+	for _, l := range q.lanes {
+		for _, kq := range l.active {
+			b := q.keyBucket(kq.key, now)
+			if b == nil {
+				return 0
+			}
+			w := b.wait(now)
+			if w == 0 {
+				return 0
+			}
+			if wait == 0 || w < wait {
+				wait = w
+			}
+		}
+	}
+	return wait
+}
+
+// evictKeys to drop the buckets of the keys unused for KeyIdle with nothing queued. It
+// runs at most once per KeyIdle and returns the events for after payloadMutex is released.
+// Must be called with payloadMutex held.
+func (q *TypedRateQueue[T]) evictKeys(now time.Time) []Event {
+	idle := q.keyIdle()
+	if len(q.keyLimits) == 0 || now.Sub(q.keysEvicted) < idle {
+		return nil
+	}
+	q.keysEvicted = now
+	var events []Event
+	for key, l := range q.keyLimits {
+		if now.Sub(l.lastUsed) > idle && q.keySize(key) == 0 {
+			delete(q.keyLimits, key)
+			events = append(events, Event{Kind: EventEvicted, Level: LevelDebug, Message: "Key Evicted [key]: " + key + ". Idle since " + l.lastUsed.String()})
+		}
+	}
+	return events
+}
+
+// keyIdle to return KeyIdle or its default of 5 minutes
+func (q *TypedRateQueue[T]) keyIdle() time.Duration {
+	if q.KeyIdle == 0 {
+		return 5 * time.Minute
+	}
+	return q.KeyIdle
+}
+
+// Keys to return the number of keys holding a rate limit bucket
+func (q *TypedRateQueue[T]) Keys() int {
+	q.payloadMutex.Lock()
+	defer q.payloadMutex.Unlock()
+	return len(q.keyLimits)
+}
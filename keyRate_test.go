@@ -0,0 +1,90 @@
+package payloadqueue_test
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sam-ish/payloadqueue"
+)
+
+func TestRateQKeyRates(t *testing.T) {
+	t.Run("Each key runs at its own rate", func(t *testing.T) {
+		var runMutex sync.Mutex
+		worked := map[string]int{}
+		q := &payloadqueue.RateQueue{
+			KeyRate:  10,
+			KeyRates: map[string]float64{"fast": 1000},
+			KeyFunc:  tenant,
+			Tag:      "RateQueueKeyRates",
+			Work: func(pl interface{}) int {
+				runMutex.Lock()
+				worked[tenant(pl)]++
+				runMutex.Unlock()
+				return 0
+			},
+		}
+		if err := q.Start(); err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		for i := 0; i < 5; i++ {
+			q.Append(payloadqueue.Payload{Id: "s" + strconv.Itoa(i), Data: "slow" + strconv.Itoa(i)})
+			q.Append(payloadqueue.Payload{Id: "f" + strconv.Itoa(i), Data: "fast" + strconv.Itoa(i)})
+		}
+		time.Sleep(150 * time.Millisecond)
+		runMutex.Lock()
+		if worked["fast"] != 5 {
+			t.Errorf("Expected the fast key to be done, got %d Work calls", worked["fast"])
+		}
+		if worked["slow"] > 2 {
+			t.Errorf("Expected the slow key to be held to its rate, got %d Work calls", worked["slow"])
+		}
+		runMutex.Unlock()
+		q.Close()
+	})
+
+	t.Run("Idle keys are evicted", func(t *testing.T) {
+		var feedMutex sync.Mutex
+		evicted := ""
+		q := &payloadqueue.RateQueue{
+			KeyRate: 100,
+			KeyIdle: 50 * time.Millisecond,
+			KeyFunc: tenant,
+			Tag:     "RateQueueKeyRates",
+			Work:    func(pl interface{}) int { return 0 },
+			EventFeed: func(s string) {
+				feedMutex.Lock()
+				if i := strings.Index(s, "Key Evicted [key]: "); i >= 0 {
+					evicted += s[i+len("Key Evicted [key]: "):][:1]
+				}
+				feedMutex.Unlock()
+			},
+		}
+		q.Start()
+		q.Append(payloadqueue.Payload{Id: "a", Data: "a"})
+		time.Sleep(100 * time.Millisecond)
+		if q.Keys() != 1 {
+			t.Errorf("Expected 1 key, got %d", q.Keys())
+		}
+		q.Append(payloadqueue.Payload{Id: "b", Data: "b"})
+		time.Sleep(50 * time.Millisecond)
+		feedMutex.Lock()
+		if evicted != "a" {
+			t.Errorf("Expected key a to be evicted, got %q", evicted)
+		}
+		feedMutex.Unlock()
+		if q.Keys() != 1 {
+			t.Errorf("Expected only key b left, got %d keys", q.Keys())
+		}
+		q.Close()
+	})
+
+	t.Run("Rate is required without key rates", func(t *testing.T) {
+		q := &payloadqueue.RateQueue{KeyFunc: tenant, Work: func(pl interface{}) int { return 0 }}
+		if err := q.Start(); err == nil {
+			t.Errorf("Expected an error without Rate or KeyRate")
+		}
+	})
+}
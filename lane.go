@@ -0,0 +1,163 @@
+package payloadqueue
+
+import (
+	"errors"
+	"time"
+)
+
+// Lane is a named priority lane of a RateQueue. Payloads are routed into it by their Lane.
+type Lane struct {
+	Name   string
+	Weight int // share of the dispatches under WeightedRoundRobin. Default is 1
+}
+
+// Scheduling to decide which lane RunNext serves next
+type Scheduling int
+
+const (
+	StrictPriority     Scheduling = iota // the first non-empty lane is always served first
+	WeightedRoundRobin                   // the non-empty lanes are served in proportion to their Weight
+)
+
+// lane holds the payloads of a single Lane in a FIFO queue per key
+type lane[T any] struct {
+	Lane
+	keys    map[string]*keyQueue[T]
+	active  []*keyQueue[T] // the non-empty keys in round-robin order
+	size    int
+	current int // smooth weighted round-robin counter
+}
+
+// validateLanes to check that the lane names are set and unique
+func validateLanes(lanes []Lane) error {
+	names := make(map[string]bool, len(lanes))
+	for _, l := range lanes {
+		if l.Name == "" {
+			return errors.New("lanes must have a Name")
+		}
+		if names[l.Name] {
+			return errors.New("lane " + l.Name + " is defined twice")
+		}
+		if l.Weight < 0 {
+			return errors.New("lane " + l.Name + " cannot have a negative Weight")
+		}
+		names[l.Name] = true
+	}
+	return nil
+}
+
+// initLanes to create the lanes on first use. A queue without Lanes has a single lane.
+// Must be called with payloadMutex held.
+func (q *TypedRateQueue[T]) initLanes() {
+	if q.lanes != nil {
+		return
+	}
+	if len(q.Lanes) == 0 {
+		q.lanes = []*lane[T]{{keys: make(map[string]*keyQueue[T])}}
+		return
+	}
+	for _, l := range q.Lanes {
+		if l.Weight == 0 {
+			l.Weight = 1
+		}
+		q.lanes = append(q.lanes, &lane[T]{Lane: l, keys: make(map[string]*keyQueue[T])})
+	}
+}
+
+// lane to return the lane of the payload. Payloads with no Lane go to the last lane.
+// Must be called with payloadMutex held.
+func (q *TypedRateQueue[T]) lane(p TypedPayload[T]) (*lane[T], error) {
+	q.initLanes()
+	if p.Lane == "" {
+		return q.lanes[len(q.lanes)-1], nil
+	}
+	for _, l := range q.lanes {
+		if l.Name == p.Lane {
+			return l, nil
+		}
+	}
+	return nil, errors.New("Payload " + p.Id + " failed. Lane " + p.Lane + " is not defined")
+}
+
+// queued to return the number of payloads in all the lanes.
+// Must be called with payloadMutex held.
+func (q *TypedRateQueue[T]) queued() int {
+	n := 0
+	for _, l := range q.lanes {
+		n += l.size
+	}
+	return n
+}
+
+// pop to remove the next payload to run, taking its key token when KeyRate is set. A
+// head payload waiting for longer than MaxWait is served first whatever its lane.
+// Must be called with payloadMutex held.
+func (q *TypedRateQueue[T]) pop() (TypedPayload[T], bool) {
+	now := q.clock().Now()
+	if q.MaxWait > 0 {
+		var starving *lane[T]
+		var oldest time.Time
+		for _, l := range q.lanes {
+			if queued := l.oldest(); !queued.IsZero() && now.Sub(queued) > q.MaxWait &&
+				(starving == nil || queued.Before(oldest)) {
+				starving, oldest = l, queued
+			}
+		}
+		if starving != nil {
+			if p, ok := q.popLane(starving, now); ok {
+				return p, true
+			}
+		}
+	}
+	if q.Scheduling != WeightedRoundRobin {
+		for _, l := range q.lanes {
+			if p, ok := q.popLane(l, now); ok {
+				return p, true
+			}
+		}
+		return TypedPayload[T]{}, false
+	}
+	// smooth weighted round-robin: every lane with a payload to run earns its weight,
+	// the richest lane is served and pays back the total
+	var best *lane[T]
+	total := 0
+	for _, l := range q.lanes {
+		if !q.runnable(l, now) {
+			continue
+		}
+		l.current += l.Weight
+		total += l.Weight
+		if best == nil || l.current > best.current {
+			best = l
+		}
+	}
+	if best == nil {
+		return TypedPayload[T]{}, false
+	}
+	best.current -= total
+	return q.popLane(best, now)
+}
+
+// oldest to return when the oldest head payload of the lane was queued
+func (l *lane[T]) oldest() time.Time {
+	var oldest time.Time
+	for _, kq := range l.active {
+		if queued := kq.payloads[0].Queued; oldest.IsZero() || queued.Before(oldest) {
+			oldest = queued
+		}
+	}
+	return oldest
+}
+
+// LaneSize to return the number of payloads in the named lane
+func (q *TypedRateQueue[T]) LaneSize(name string) int {
+	q.payloadMutex.Lock()
+	defer q.payloadMutex.Unlock()
+	q.initLanes()
+	for _, l := range q.lanes {
+		if l.Name == name {
+			return l.size
+		}
+	}
+	return 0
+}
@@ -0,0 +1,119 @@
+package payloadqueue_test
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sam-ish/payloadqueue"
+)
+
+// laneQueue to return a paused RateQueue recording the order Work is called in
+func laneQueue(t *testing.T, q *payloadqueue.RateQueue) (func() []string, func()) {
+	var runMutex sync.Mutex
+	var worked []string
+	q.Rate = 1000
+	q.Tag = "RateQueueLanes"
+	q.Work = func(pl interface{}) int {
+		runMutex.Lock()
+		defer runMutex.Unlock()
+		worked = append(worked, pl.(string))
+		return 0
+	}
+	if err := q.Start(); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	q.Pause()
+	order := func() []string {
+		runMutex.Lock()
+		defer runMutex.Unlock()
+		return append([]string(nil), worked...)
+	}
+	run := func() {
+		q.Restart()
+		time.Sleep(100 * time.Millisecond)
+	}
+	return order, run
+}
+
+func TestRateQLanes(t *testing.T) {
+	t.Run("Strict priority serves the first lane first", func(t *testing.T) {
+		q := &payloadqueue.RateQueue{
+			Lanes: []payloadqueue.Lane{{Name: "urgent"}, {Name: "bulk"}},
+		}
+		order, run := laneQueue(t, q)
+		for i := 0; i < 3; i++ {
+			q.Append(payloadqueue.Payload{Id: "b" + strconv.Itoa(i), Data: "bulk"})
+		}
+		q.Append(payloadqueue.Payload{Id: "u", Data: "urgent", Lane: "urgent"})
+		if q.LaneSize("urgent") != 1 || q.LaneSize("bulk") != 3 {
+			t.Errorf("Expected 1 urgent and 3 bulk payloads, got %d and %d", q.LaneSize("urgent"), q.LaneSize("bulk"))
+		}
+		run()
+		if got := strings.Join(order(), ","); got != "urgent,bulk,bulk,bulk" {
+			t.Errorf("Expected the urgent payload first, got %s", got)
+		}
+		q.Close()
+	})
+
+	t.Run("Weighted round-robin shares the dispatches", func(t *testing.T) {
+		q := &payloadqueue.RateQueue{
+			Lanes:      []payloadqueue.Lane{{Name: "a", Weight: 3}, {Name: "b", Weight: 1}},
+			Scheduling: payloadqueue.WeightedRoundRobin,
+		}
+		order, run := laneQueue(t, q)
+		for i := 0; i < 8; i++ {
+			q.Append(payloadqueue.Payload{Id: "a" + strconv.Itoa(i), Data: "a", Lane: "a"})
+			q.Append(payloadqueue.Payload{Id: "b" + strconv.Itoa(i), Data: "b", Lane: "b"})
+		}
+		run()
+		got := order()
+		if len(got) != 16 {
+			t.Fatalf("Expected 16 Work calls, got %d", len(got))
+		}
+		if first := strings.Join(got[:8], ""); strings.Count(first, "a") != 6 {
+			t.Errorf("Expected 6 of the first 8 dispatches from lane a, got %s", first)
+		}
+		q.Close()
+	})
+
+	t.Run("MaxWait protects a starving lane", func(t *testing.T) {
+		q := &payloadqueue.RateQueue{
+			Lanes:   []payloadqueue.Lane{{Name: "urgent"}, {Name: "bulk"}},
+			MaxWait: time.Minute,
+		}
+		order, run := laneQueue(t, q)
+		for i := 0; i < 3; i++ {
+			q.Append(payloadqueue.Payload{Id: "u" + strconv.Itoa(i), Data: "urgent", Lane: "urgent"})
+		}
+		q.Append(payloadqueue.Payload{Id: "b", Data: "bulk", Queued: time.Now().Add(-time.Hour)})
+		run()
+		if got := order(); len(got) == 0 || got[0] != "bulk" {
+			t.Errorf("Expected the starving bulk payload first, got %v", got)
+		}
+		q.Close()
+	})
+
+	t.Run("Unknown and duplicate lanes are rejected", func(t *testing.T) {
+		q := &payloadqueue.RateQueue{
+			Lanes: []payloadqueue.Lane{{Name: "urgent"}},
+		}
+		_, run := laneQueue(t, q)
+		if err := q.Append(payloadqueue.Payload{Id: "x", Data: "x", Lane: "other"}); err == nil {
+			t.Errorf("Expected an error for an unknown lane")
+		}
+		run()
+		q.Close()
+
+		q = &payloadqueue.RateQueue{
+			Rate:  1,
+			Lanes: []payloadqueue.Lane{{Name: "urgent"}, {Name: "urgent"}},
+			Work:  func(pl interface{}) int { return 0 },
+		}
+		if err := q.Start(); err == nil {
+			t.Errorf("Expected an error for a duplicate lane")
+		}
+	})
+}
@@ -0,0 +1,113 @@
+package payloadqueue
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter hands out the tokens of a RateQueue. By default a RateQueue has an in-process
+// token bucket for Rate; a shared Limiter such as RedisLimiter spreads one rate across
+// the replicas of a service.
+type Limiter interface {
+	// Take to consume a token. When none is available, the time until the next one is
+	// returned and nothing is consumed.
+	Take(ctx context.Context) (time.Duration, error)
+}
+
+// limiterBackoff is how long a RateQueue waits before asking a failing Limiter again
+const limiterBackoff = time.Second
+
+// maxLateness is how late a dispatcher can wake up for a token and still catch up on
+// the tokens earned meanwhile. Timers are not precise enough for rates above ~1000/s.
+const maxLateness = 50 * time.Millisecond
+
+// tokenBucket refills at rate tokens per second up to burst. It starts empty so that
+// Start does not fire a burst of Work straight away.
+type tokenBucket struct {
+	mutex  sync.Mutex
+	rate   float64 // tokens per second
+	burst  float64
+	tokens float64
+	last   time.Time
+	due    time.Time // when the token last waited for becomes available
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), last: now}
+}
+
+// take to consume a token. When none is available, the time until the next one is
+// returned and nothing is consumed.
+func (b *tokenBucket) take(now time.Time) time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refill(now)
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	wait := b.until()
+	b.due = now.Add(wait)
+	return wait
+}
+
+// wait to return the time until a token is available without consuming it
+func (b *tokenBucket) wait(now time.Time) time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.refill(now)
+	if b.tokens >= 1 {
+		return 0
+	}
+	return b.until()
+}
+
+// refill to add the tokens earned since the last refill. Must be called with mutex held.
+func (b *tokenBucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		limit := b.burst
+		if late := now.Sub(b.due); late > 0 && late < maxLateness {
+			// keep the tokens earned while the dispatcher was waking up
+			limit += late.Seconds() * b.rate
+		}
+		b.tokens += elapsed * b.rate
+		if b.tokens > limit {
+			b.tokens = limit
+		}
+		b.last = now
+	}
+}
+
+// until to return the time until the next token. Must be called with mutex held.
+func (b *tokenBucket) until() time.Duration {
+	wait := time.Duration((1 - b.tokens) / b.rate * float64(time.Second))
+	if wait < 1 {
+		wait = 1
+	}
+	return wait
+}
+
+// setRate to change the refill rate. The tokens earned so far are kept.
+func (b *tokenBucket) setRate(now time.Time, rate float64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+	b.rate = rate
+}
+
+// currentRate to return the refill rate in tokens per second
+func (b *tokenBucket) currentRate() float64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.rate
+}
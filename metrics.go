@@ -0,0 +1,112 @@
+package payloadqueue
+
+import "time"
+
+// FlushReason tells why a Queue batch was flushed
+type FlushReason int
+
+const (
+	FlushSize      FlushReason = iota // MaxSize was reached
+	FlushBytes                        // MaxBytes was reached
+	FlushAge                          // MaxAge expired
+	FlushEvicted                      // the partition was evicted for MaxPartitions
+	FlushOversized                    // a payload above MaxBytes was sent alone
+	FlushManual                       // Flush, FlushAndWait, FlushSignal or Close was called
+)
+
+func (r FlushReason) String() string {
+	switch r {
+	case FlushSize:
+		return "size"
+	case FlushBytes:
+		return "bytes"
+	case FlushAge:
+		return "age"
+	case FlushEvicted:
+		return "evicted"
+	case FlushOversized:
+		return "oversized"
+	case FlushManual:
+		return "manual"
+	}
+	return "unknown"
+}
+
+// The reasons given to Metrics.Rejected
+const (
+	RejectedClosed   = "closed"    // the queue is closed
+	RejectedFull     = "full"      // the RateQueue has MaxSize payloads
+	RejectedKeyFull  = "key_full"  // the RateQueue key has KeyMaxSize payloads
+	RejectedLane     = "lane"      // the RateQueue Lane is not defined
+	RejectedBusy     = "busy"      // all MaxConcurrentBatches workers are busy
+	RejectedTooLarge = "too_large" // the payload is above MaxBytes
+	RejectedWAL      = "wal"       // the payload cannot be written to the WAL
+)
+
+// Metrics receives the measurements of the queues, labelled by their Tag. The methods are
+// called synchronously by the queues, so they must be quick and safe for concurrent use.
+type Metrics interface {
+	Enqueued(tag string)                              // a payload was appended
+	Rejected(tag string, reason string)               // a payload was refused by Append
+	Flushed(tag string, reason FlushReason, size int) // a Queue batch was flushed
+	InFlight(tag string, n int)                       // batches or Work calls running
+	WorkDuration(tag string, d time.Duration)         // time taken by a Work call
+	Results(tag string, code int, n int)              // n payloads got the result code
+	Depth(tag string, n int)                          // payloads waiting in the queue
+	Rate(tag string, rate float64)                    // RateQueue effective rate per Per
+}
+
+// noMetrics is used when no Metrics is supplied
+type noMetrics struct{}
+
+func (noMetrics) Enqueued(string)                    {}
+func (noMetrics) Rejected(string, string)            {}
+func (noMetrics) Flushed(string, FlushReason, int)   {}
+func (noMetrics) InFlight(string, int)               {}
+func (noMetrics) WorkDuration(string, time.Duration) {}
+func (noMetrics) Results(string, int, int)           {}
+func (noMetrics) Depth(string, int)                  {}
+func (noMetrics) Rate(string, float64)               {}
+
+// metrics to return the Metrics of the queue, or a no-op
+func (q *TypedQueue[T]) metrics() Metrics {
+	if q.Metrics == nil {
+		return noMetrics{}
+	}
+	return q.Metrics
+}
+
+// measureDepth to report the payloads buffered in the partitions.
+// Must be called with payloadMutex held.
+func (q *TypedQueue[T]) measureDepth() {
+	if q.Metrics == nil {
+		return
+	}
+	n := 0
+	for _, pt := range q.partitions {
+		n += len(pt.payloads)
+	}
+	q.Metrics.Depth(q.Tag, n)
+}
+
+// measureResults to report the result codes of a batch
+func (q *TypedQueue[T]) measureResults(results []int) {
+	if q.Metrics == nil {
+		return
+	}
+	counts := make(map[int]int)
+	for _, r := range results {
+		counts[r]++
+	}
+	for code, n := range counts {
+		q.Metrics.Results(q.Tag, code, n)
+	}
+}
+
+// metrics to return the Metrics of the queue, or a no-op
+func (q *TypedRateQueue[T]) metrics() Metrics {
+	if q.Metrics == nil {
+		return noMetrics{}
+	}
+	return q.Metrics
+}
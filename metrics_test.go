@@ -0,0 +1,148 @@
+package payloadqueue_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sam-ish/payloadqueue"
+)
+
+// recorder is a Metrics that counts the calls it receives
+type recorder struct {
+	mutex    sync.Mutex
+	enqueued int
+	rejected map[string]int
+	flushed  map[payloadqueue.FlushReason]int
+	results  map[int]int
+	worked   int
+	rate     float64
+	rateTag  string
+}
+
+func newRecorder() *recorder {
+	return &recorder{rejected: map[string]int{}, flushed: map[payloadqueue.FlushReason]int{}, results: map[int]int{}}
+}
+
+func (r *recorder) Enqueued(tag string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.enqueued++
+}
+
+func (r *recorder) Rejected(tag string, reason string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.rejected[reason]++
+}
+
+func (r *recorder) Flushed(tag string, reason payloadqueue.FlushReason, size int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.flushed[reason]++
+}
+
+func (r *recorder) InFlight(tag string, n int) {}
+
+func (r *recorder) WorkDuration(tag string, d time.Duration) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.worked++
+}
+
+func (r *recorder) Results(tag string, code int, n int) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.results[code] += n
+}
+
+func (r *recorder) Depth(tag string, n int) {}
+
+func (r *recorder) Rate(tag string, rate float64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.rate = rate
+	r.rateTag = tag
+}
+
+func TestQueueMetrics(t *testing.T) {
+	m := newRecorder()
+	q := &payloadqueue.TypedQueue[string]{
+		MaxSize:  2,
+		MaxBytes: 10,
+		MaxAge:   100,
+		Tag:      "QueueMetrics",
+		Metrics:  m,
+		SizeFunc: func(s string) int { return len(s) },
+		Work:     func(pls []string) int { return 0 },
+	}
+	q.Start()
+	q.Append(q.NewPayload("a"))
+	q.Append(q.NewPayload("b"))
+	q.Append(q.NewPayload("123456"))
+	q.Append(q.NewPayload("789012"))
+	q.Append(q.NewPayload("far too large"))
+	time.Sleep(50 * time.Millisecond)
+	q.Close()
+	q.Append(q.NewPayload("c"))
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.enqueued != 4 {
+		t.Errorf("Expected 4 payloads enqueued, got %d", m.enqueued)
+	}
+	if m.flushed[payloadqueue.FlushSize] != 1 || m.flushed[payloadqueue.FlushBytes] != 1 || m.flushed[payloadqueue.FlushManual] != 1 {
+		t.Errorf("Expected a flush by size, one by bytes and one on Close, got %v", m.flushed)
+	}
+	if m.rejected[payloadqueue.RejectedTooLarge] != 1 || m.rejected[payloadqueue.RejectedClosed] != 1 {
+		t.Errorf("Expected too_large and closed rejections, got %v", m.rejected)
+	}
+	if m.worked != 3 || m.results[0] != 4 {
+		t.Errorf("Expected 3 Work calls with 4 results, got %d and %v", m.worked, m.results)
+	}
+}
+
+func TestRateQMetrics(t *testing.T) {
+	m := newRecorder()
+	q := &payloadqueue.RateQueue{
+		Rate:    1000,
+		MaxSize: 2,
+		Tag:     "RateQueueMetrics",
+		Lanes:   []payloadqueue.Lane{{Name: "high"}},
+		Metrics: m,
+		Work:    func(pl interface{}) int { return 1 },
+	}
+	q.Start()
+	q.Append(payloadqueue.Payload{Id: "1", Data: 1})
+	q.Append(payloadqueue.Payload{Id: "2", Data: 2, Lane: "missing"})
+	time.Sleep(50 * time.Millisecond)
+	q.Close()
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.rate != 1000 {
+		t.Errorf("Expected the rate to be reported, got %f", m.rate)
+	}
+	if m.enqueued != 1 || m.rejected[payloadqueue.RejectedLane] != 1 {
+		t.Errorf("Expected 1 payload enqueued and 1 rejected by lane, got %d and %v", m.enqueued, m.rejected)
+	}
+	if m.worked != 1 || m.results[1] != 1 {
+		t.Errorf("Expected a Work call with result 1, got %d and %v", m.worked, m.results)
+	}
+}
+
+func TestRateQMetricsDefaultTag(t *testing.T) {
+	m := newRecorder()
+	q := &payloadqueue.RateQueue{
+		Rate:    1000,
+		Metrics: m,
+		Work:    func(pl interface{}) int { return 0 },
+	}
+	q.Start()
+	q.Close()
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.rateTag == "" || m.rateTag != q.Tag {
+		t.Errorf("Expected the rate to be reported under the default Tag %q, got %q", q.Tag, m.rateTag)
+	}
+}
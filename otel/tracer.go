@@ -0,0 +1,89 @@
+// Package otel implements the payloadqueue Tracer with OpenTelemetry
+package otel
+
+import (
+	"context"
+	"strconv"
+
+	gotel "go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/sam-ish/payloadqueue"
+)
+
+const instrumentation = "github.com/sam-ish/payloadqueue"
+
+// The attributes set on the Work spans
+const (
+	TagKey        = attribute.Key("payloadqueue.tag")
+	BatchSizeKey  = attribute.Key("payloadqueue.batch_size")
+	ResultCodeKey = attribute.Key("payloadqueue.result_code")
+	FailedKey     = attribute.Key("payloadqueue.failed")
+)
+
+// Tracer starts a consumer span for each Work call, linked to the spans of the
+// producers that appended its payloads with AppendContext
+type Tracer struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+var _ payloadqueue.Tracer = (*Tracer)(nil)
+
+// NewTracer to create a Tracer from tp. A nil tp uses the global TracerProvider.
+// The span contexts are captured in the W3C trace context format.
+func NewTracer(tp trace.TracerProvider) *Tracer {
+	if tp == nil {
+		tp = gotel.GetTracerProvider()
+	}
+	return &Tracer{
+		tracer:     tp.Tracer(instrumentation),
+		propagator: propagation.TraceContext{},
+	}
+}
+
+// Capture implements payloadqueue.Tracer
+func (t *Tracer) Capture(ctx context.Context) payloadqueue.TraceContext {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return nil
+	}
+	carrier := propagation.MapCarrier{}
+	t.propagator.Inject(ctx, carrier)
+	return payloadqueue.TraceContext(carrier)
+}
+
+// Start implements payloadqueue.Tracer. The result code attribute is the first
+// failed code of the batch, or 0, and the span status is an error if any failed.
+func (t *Tracer) Start(ctx context.Context, tag string, links []payloadqueue.TraceContext, size int) (context.Context, func([]int)) {
+	spanLinks := make([]trace.Link, 0, len(links))
+	for _, l := range links {
+		sc := trace.SpanContextFromContext(t.propagator.Extract(context.Background(), propagation.MapCarrier(l)))
+		if sc.IsValid() {
+			spanLinks = append(spanLinks, trace.Link{SpanContext: sc})
+		}
+	}
+	ctx, span := t.tracer.Start(ctx, tag+" work",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithLinks(spanLinks...),
+		trace.WithAttributes(TagKey.String(tag), BatchSizeKey.Int(size)),
+	)
+	return ctx, func(results []int) {
+		code, failed := 0, 0
+		for _, r := range results {
+			if r != 0 {
+				if failed == 0 {
+					code = r
+				}
+				failed++
+			}
+		}
+		span.SetAttributes(ResultCodeKey.Int(code), FailedKey.Int(failed))
+		if failed > 0 {
+			span.SetStatus(codes.Error, strconv.Itoa(failed)+" of "+strconv.Itoa(len(results))+" failed")
+		}
+		span.End()
+	}
+}
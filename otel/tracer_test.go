@@ -0,0 +1,131 @@
+package otel_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/sam-ish/payloadqueue"
+	"github.com/sam-ish/payloadqueue/otel"
+)
+
+// attr to find an attribute of the span
+func attr(s sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, kv := range s.Attributes() {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTracer(t *testing.T) {
+	t.Run("The batch span links to the producer spans", func(t *testing.T) {
+		recorder := tracetest.NewSpanRecorder()
+		tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+		var workSpan trace.SpanContext
+		var workMutex sync.Mutex
+		q := &payloadqueue.Queue{
+			MaxSize: 2,
+			MaxAge:  1000,
+			Tag:     "QueueTracer",
+			Tracer:  otel.NewTracer(tp),
+			WorkEachContext: func(ctx context.Context, pls []interface{}) []int {
+				workMutex.Lock()
+				workSpan = trace.SpanContextFromContext(ctx)
+				workMutex.Unlock()
+				return []int{0, 3}
+			},
+		}
+		q.Start()
+		var producers []trace.SpanContext
+		for _, id := range []string{"1", "2"} {
+			ctx, span := tp.Tracer("test").Start(context.Background(), "produce "+id)
+			q.AppendContext(ctx, payloadqueue.Payload{Id: id, Data: id})
+			producers = append(producers, span.SpanContext())
+			span.End()
+		}
+		time.Sleep(50 * time.Millisecond)
+		q.Close()
+
+		var batch sdktrace.ReadOnlySpan
+		for _, s := range recorder.Ended() {
+			if s.Name() == "QueueTracer work" {
+				batch = s
+			}
+		}
+		if batch == nil {
+			t.Fatalf("Expected a span for the batch")
+		}
+		workMutex.Lock()
+		if workSpan.SpanID() != batch.SpanContext().SpanID() {
+			t.Errorf("Expected Work to run in the batch span")
+		}
+		workMutex.Unlock()
+		if len(batch.Links()) != 2 {
+			t.Fatalf("Expected 2 links, got %d", len(batch.Links()))
+		}
+		for i, l := range batch.Links() {
+			if l.SpanContext.SpanID() != producers[i].SpanID() || l.SpanContext.TraceID() != producers[i].TraceID() {
+				t.Errorf("Expected link %d to the producer span", i)
+			}
+		}
+		if got := attr(batch, otel.TagKey).AsString(); got != "QueueTracer" {
+			t.Errorf("Expected the tag attribute, got %q", got)
+		}
+		if got := attr(batch, otel.BatchSizeKey).AsInt64(); got != 2 {
+			t.Errorf("Expected a batch size of 2, got %d", got)
+		}
+		if got := attr(batch, otel.ResultCodeKey).AsInt64(); got != 3 {
+			t.Errorf("Expected the result code 3, got %d", got)
+		}
+	})
+
+	t.Run("RateQueue spans link to the producer span", func(t *testing.T) {
+		recorder := tracetest.NewSpanRecorder()
+		tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+		q := &payloadqueue.RateQueue{
+			Rate:   1000,
+			Tag:    "RateQueueTracer",
+			Tracer: otel.NewTracer(tp),
+			Work:   func(pl interface{}) int { return 0 },
+		}
+		q.Start()
+		ctx, span := tp.Tracer("test").Start(context.Background(), "produce")
+		q.AppendContext(ctx, payloadqueue.Payload{Id: "1", Data: 1})
+		span.End()
+		q.Append(payloadqueue.Payload{Id: "2", Data: 2})
+		time.Sleep(50 * time.Millisecond)
+		q.Close()
+
+		var linked, unlinked int
+		for _, s := range recorder.Ended() {
+			if s.Name() != "RateQueueTracer work" {
+				continue
+			}
+			switch len(s.Links()) {
+			case 0:
+				unlinked++
+			case 1:
+				if s.Links()[0].SpanContext.SpanID() == span.SpanContext().SpanID() {
+					linked++
+				}
+			}
+		}
+		if linked != 1 || unlinked != 1 {
+			t.Errorf("Expected a linked and an unlinked span, got %d and %d", linked, unlinked)
+		}
+	})
+
+	t.Run("Capture ignores a context without a span", func(t *testing.T) {
+		if tc := otel.NewTracer(nil).Capture(context.Background()); tc != nil {
+			t.Errorf("Expected no trace context, got %v", tc)
+		}
+	})
+}
@@ -0,0 +1,132 @@
+package payloadqueue
+
+import (
+	"strconv"
+	"time"
+)
+
+// keyed work handler to receive the partition key with its homogeneous batch
+type keyedWorkHandler[T any] func(string, []T) int
+
+// partition holds the payloads of a single key until they are flushed as one batch.
+// A queue without a KeyFunc has a single partition with an empty key.
+type partition[T any] struct {
+	payloads []TypedPayload[T]
+	bytes    int       // total size of the payloads when MaxBytes is set
+	expires  time.Time // when the oldest payload reaches MaxAge
+	lastUsed time.Time
+}
+
+// key to return the partition key of the data
+func (q *TypedQueue[T]) key(data T) string {
+	if q.KeyFunc == nil {
+		return ""
+	}
+	return q.KeyFunc(data)
+}
+
+// partition to return the partition for the key, creating it when needed. When
+// MaxPartitions is reached, an empty partition is evicted or else the least recently
+// used one is flushed and evicted. Must be called with payloadMutex held.
+func (q *TypedQueue[T]) partition(h *held, key string) (*partition[T], error) {
+	if pt, ok := q.partitions[key]; ok {
+		return pt, nil
+	}
+	if q.partitions == nil {
+		q.partitions = make(map[string]*partition[T])
+	}
+	if q.MaxPartitions > 0 && len(q.partitions) >= q.MaxPartitions {
+		evict, oldest := "", time.Time{}
+		for k, pt := range q.partitions {
+			if len(pt.payloads) == 0 {
+				evict = k
+				break
+			}
+			if oldest.IsZero() || pt.lastUsed.Before(oldest) {
+				evict, oldest = k, pt.lastUsed
+			}
+		}
+		switch err := q.flush(h, evict, q.partitions[evict], FlushEvicted); err {
+		case nil:
+			delete(q.partitions, evict)
+			h.event(Event{Kind: EventEvicted, Message: "Partition Evicted [key]: " + evict + ". Partitions: " + strconv.Itoa(len(q.partitions))})
+		case errBreakerOpen:
+			// the payloads are held, so MaxPartitions is exceeded until the Breaker closes
+		default:
+			return nil, errBusy
+		}
+	}
+	pt := &partition[T]{lastUsed: q.clock().Now()}
+	q.partitions[key] = pt
+	return pt, nil
+}
+
+// flushDue to flush the partitions that are full or expired and evict the partitions
+// that have been empty for PartitionIdle. Must be called with payloadMutex held.
+func (q *TypedQueue[T]) flushDue(h *held) {
+	now := q.clock().Now()
+	for k, pt := range q.partitions {
+		if len(pt.payloads) >= q.MaxSize {
+			q.flush(h, k, pt, FlushSize)
+			continue
+		}
+		if len(pt.payloads) > 0 && now.After(pt.expires) {
+			q.flush(h, k, pt, FlushAge)
+			continue
+		}
+		if len(pt.payloads) == 0 && q.KeyFunc != nil && now.Sub(pt.lastUsed) > q.partitionIdle() {
+			delete(q.partitions, k)
+			h.event(Event{Kind: EventEvicted, Level: LevelDebug, Message: "Partition Evicted [key]: " + k + ". Idle since " + pt.lastUsed.String()})
+		}
+	}
+}
+
+// flush to hand the payloads of the partition to Work and reset it. An error is returned
+// when a batch is rejected, the queue is closed or the Breaker is open, leaving the
+// payloads not dispatched in the partition. The Breaker is consulted per batch, so a
+// half-open one only lets its trials through. Must be called with payloadMutex held.
+func (q *TypedQueue[T]) flush(h *held, key string, pt *partition[T], reason FlushReason) error {
+	if len(pt.payloads) == 0 {
+		return nil
+	}
+	if q.closed {
+		return errClosed
+	}
+	for len(pt.payloads) > 0 {
+		allowed, changed := q.allow()
+		h.breakerChanged(changed)
+		if !allowed {
+			h.event(Event{Kind: EventBatchHeld, Level: LevelWarn, Size: len(pt.payloads), Err: errBreakerOpen, Message: "Batch Push [" + q.Tag + "]: Held. " + strconv.Itoa(len(pt.payloads)) + " payload(s) in the partition. " + errBreakerOpen.Error()})
+			return errBreakerOpen
+		}
+		n, bytes := q.batchLen(h, pt)
+		if !q.dispatch(h, key, pt.payloads[:n]) {
+			if q.Breaker != nil {
+				q.Breaker.release()
+			}
+			return errBusy
+		}
+		pt.payloads = pt.payloads[n:]
+		pt.bytes -= bytes
+		q.metrics().Flushed(q.Tag, reason, n)
+	}
+	pt.payloads = nil
+	pt.bytes = 0
+	q.measureDepth()
+	return nil
+}
+
+// partitionIdle to return PartitionIdle or its default of 5 minutes
+func (q *TypedQueue[T]) partitionIdle() time.Duration {
+	if q.PartitionIdle == 0 {
+		return 5 * time.Minute
+	}
+	return q.PartitionIdle
+}
+
+// Partitions to return the number of live partitions
+func (q *TypedQueue[T]) Partitions() int {
+	q.payloadMutex.Lock()
+	defer q.payloadMutex.Unlock()
+	return len(q.partitions)
+}
@@ -0,0 +1,119 @@
+package payloadqueue_test
+
+import (
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sam-ish/payloadqueue"
+)
+
+func TestQueuePartitions(t *testing.T) {
+	type job struct {
+		Tenant string
+		Name   string
+	}
+
+	t.Run("Batches hold a single key", func(t *testing.T) {
+		var runMutex sync.Mutex
+		batches := map[string][]string{}
+
+		q := &payloadqueue.TypedQueue[job]{
+			MaxSize: 2,
+			MaxAge:  200,
+			Tag:     "QueuePartitions",
+			KeyFunc: func(j job) string { return j.Tenant },
+			WorkKeyed: func(key string, jobs []job) int {
+				runMutex.Lock()
+				defer runMutex.Unlock()
+				for _, j := range jobs {
+					if j.Tenant != key {
+						t.Errorf("Expected tenant %s in batch %s", j.Tenant, key)
+					}
+					batches[key] = append(batches[key], j.Name)
+				}
+				return 0
+			},
+		}
+		q.Start()
+		q.Append(q.NewPayload(job{Tenant: "a", Name: "a1"}))
+		q.Append(q.NewPayload(job{Tenant: "b", Name: "b1"}))
+		q.Append(q.NewPayload(job{Tenant: "b", Name: "b2"}))
+		q.Append(q.NewPayload(job{Tenant: "a", Name: "a2"}))
+		q.Append(q.NewPayload(job{Tenant: "c", Name: "c1"}))
+		time.Sleep(100 * time.Millisecond)
+
+		runMutex.Lock()
+		if len(batches) != 2 || len(batches["a"]) != 2 || len(batches["b"]) != 2 {
+			t.Errorf("Expected full batches for a and b, got %v", batches)
+		}
+		runMutex.Unlock()
+		if q.Size() != 1 || q.Partitions() != 3 {
+			t.Errorf("Expected 1 payload in 3 partitions, got %d in %d", q.Size(), q.Partitions())
+		}
+		q.Close()
+	})
+
+	t.Run("MaxPartitions flushes the least recently used partition", func(t *testing.T) {
+		var runMutex sync.Mutex
+		var flushed []string
+
+		q := &payloadqueue.TypedQueue[job]{
+			MaxSize:       10,
+			MaxAge:        200,
+			MaxPartitions: 2,
+			Tag:           "QueuePartitions",
+			KeyFunc:       func(j job) string { return j.Tenant },
+			Work: func(jobs []job) int {
+				runMutex.Lock()
+				for _, j := range jobs {
+					flushed = append(flushed, j.Name)
+				}
+				runMutex.Unlock()
+				return 0
+			},
+		}
+		// the eviction event handler can call back into the queue
+		q.EventFeed = func(string) { q.Partitions() }
+		q.Start()
+		q.Append(q.NewPayload(job{Tenant: "a", Name: "a1"}))
+		time.Sleep(10 * time.Millisecond)
+		q.Append(q.NewPayload(job{Tenant: "b", Name: "b1"}))
+		q.Append(q.NewPayload(job{Tenant: "c", Name: "c1"}))
+		time.Sleep(100 * time.Millisecond)
+
+		runMutex.Lock()
+		sort.Strings(flushed)
+		if len(flushed) != 1 || flushed[0] != "a1" {
+			t.Errorf("Expected partition a to be flushed, got %v", flushed)
+		}
+		runMutex.Unlock()
+		if q.Partitions() != 2 {
+			t.Errorf("Expected 2 partitions, got %d", q.Partitions())
+		}
+		q.Close()
+	})
+
+	t.Run("Idle partitions are evicted", func(t *testing.T) {
+		q := &payloadqueue.TypedQueue[job]{
+			MaxSize:       1,
+			MaxAge:        200,
+			PartitionIdle: time.Millisecond,
+			Tag:           "QueuePartitions",
+			KeyFunc:       func(j job) string { return j.Tenant },
+			Work:          func(jobs []job) int { return 0 },
+		}
+		q.EventFeed = func(string) { q.Partitions() }
+		q.Start()
+		q.Append(q.NewPayload(job{Tenant: "a", Name: "a1"}))
+		q.Append(q.NewPayload(job{Tenant: "b", Name: "b1"}))
+		time.Sleep(10 * time.Millisecond)
+		q.Append(payloadqueue.TypedPayload[job]{})
+
+		if q.Partitions() != 0 {
+			t.Errorf("Expected idle partitions to be evicted, got %d", q.Partitions())
+		}
+		q.Close()
+	})
+}
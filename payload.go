@@ -1,19 +1,82 @@
 package payloadqueue
 
-import "math/rand"
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
 
-type Payload struct {
-	Id   string
-	Data interface{}
+	"github.com/google/uuid"
+)
+
+// TypedPayload wraps a single item of type T queued for future work.
+type TypedPayload[T any] struct {
+	Id          string
+	Data        T
+	Attempts    int          // number of times Work has been called with the payload
+	Result      int          // result code of the last Work call
+	Queued      time.Time    // when the payload was first appended
+	LastAttempt time.Time    // when Work was last called with the payload
+	Lane        string       // name of the RateQueue Lane. Empty is the last lane
+	NotBefore   time.Time    // the payload is held until then. Set by AppendAt and AppendAfter
+	Trace       TraceContext // span context of the producer. Set by AppendContext
 }
 
+// Payload is the interface{}-based payload used by Queue and RateQueue.
+type Payload = TypedPayload[interface{}]
+
 // work to be implemented by the consumer to handle the batched (array) payload
-type workHandler func([]interface{}) int
-type rateWorkHandler func(interface{}) int
+type workHandler[T any] func([]T) int
+type rateWorkHandler[T any] func(T) int
+
+// context-aware work handlers. The context is cancelled when the queue is closed
+// with CloseContext and the shutdown deadline passes.
+type workContextHandler[T any] func(context.Context, []T) int
+type rateWorkContextHandler[T any] func(context.Context, T) int
+
+// batch work handlers that return one result code per item, in the order of the batch,
+// so only the failed items are retried or dead-lettered.
+type batchWorkHandler[T any] func([]T) []int
+type batchWorkContextHandler[T any] func(context.Context, []T) []int
+
+// Result is returned by the RateQueue WorkResult handler. A RetryAfter above zero, e.g.
+// from an upstream Retry-After header, pauses the dispatching for that long and puts
+// the payload back at the head of the queue. The attempts are capped by the Retry
+// policy of the queue, if any.
+type Result struct {
+	Code       int
+	RetryAfter time.Duration
+}
+
+type rateWorkResultHandler[T any] func(context.Context, T) Result
+
+// errClosed is returned by Append once the queue is closed
+var errClosed = errors.New("the queue is closed")
 
 // eventFeed to pass information/verbose to the client for handling
 type eventFeed func(string)
 
+// payloadIds to return the Ids of the payloads
+func payloadIds[T any](pls []TypedPayload[T]) []string {
+	ids := make([]string, 0, len(pls))
+	for _, p := range pls {
+		ids = append(ids, p.Id)
+	}
+	return ids
+}
+
+// NewPayload to wrap data into a payload with a new unique Id.
+// A nil data returns an empty payload which is ignored by Append.
+func NewPayload[T any](data T) TypedPayload[T] {
+	if any(data) == nil {
+		return TypedPayload[T]{}
+	}
+	return TypedPayload[T]{
+		Id:   uuid.New().String(),
+		Data: data,
+	}
+}
+
 func defaultTag(n int) string {
 	var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
 	b := make([]rune, n)
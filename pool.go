@@ -0,0 +1,147 @@
+package payloadqueue
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+)
+
+// OverflowPolicy to decide what happens to a ready batch when MaxConcurrentBatches
+// batches are already running
+type OverflowPolicy int
+
+const (
+	OverflowBuffer OverflowPolicy = iota // hold the batch until a worker is free
+	OverflowBlock                        // block Append until a worker is free
+	OverflowReject                       // reject the Append with an error
+)
+
+// errBusy is returned by Append when OverflowReject is used and all workers are busy
+var errBusy = errors.New("all MaxConcurrentBatches workers are busy. Try again later")
+
+// batch is a flushed partition waiting for a worker
+type batch[T any] struct {
+	key      string
+	payloads []TypedPayload[T]
+	wait     *sync.WaitGroup // done once Work returns, for FlushAndWait
+	started  chan struct{}   // closed once a worker takes the batch, for OverflowBlock
+}
+
+// dispatch to run the batch on a worker. It returns false when the batch is rejected
+// by OverflowReject, in which case the caller keeps the payloads. With OverflowBlock,
+// the batch waits for a worker like a buffered one and the caller blocks once it has
+// released payloadMutex. Must be called with payloadMutex held.
+func (q *TypedQueue[T]) dispatch(h *held, key string, pls []TypedPayload[T]) bool {
+	b := batch[T]{key: key, payloads: pls, wait: q.flushWait}
+	if b.wait != nil {
+		b.wait.Add(1)
+	}
+	q.activeWork.Add(1)
+	if q.MaxConcurrentBatches <= 0 {
+		go func() {
+			defer q.activeWork.Done()
+			q.runBatch(b)
+		}()
+		return true
+	}
+	q.poolOnce.Do(func() {
+		q.workers = make(chan struct{}, q.MaxConcurrentBatches)
+	})
+	switch q.Overflow {
+	case OverflowBlock:
+		q.readyMutex.Lock()
+		select {
+		case q.workers <- struct{}{}:
+		default:
+			b.started = make(chan struct{})
+			q.ready = append(q.ready, b)
+			q.readyMutex.Unlock()
+			h.waiting = append(h.waiting, b.started)
+			return true
+		}
+		q.readyMutex.Unlock()
+	case OverflowReject:
+		select {
+		case q.workers <- struct{}{}:
+		default:
+			q.activeWork.Done()
+			if b.wait != nil {
+				b.wait.Done()
+			}
+			h.event(Event{Kind: EventBatchRejected, Level: LevelWarn, Size: len(pls), Err: errBusy, Message: "Batch Push [" + q.Tag + "]: Rejected. All " + strconv.Itoa(q.MaxConcurrentBatches) + " workers are busy"})
+			return false
+		}
+	default:
+		q.readyMutex.Lock()
+		select {
+		case q.workers <- struct{}{}:
+		default:
+			q.ready = append(q.ready, b)
+			ready := len(q.ready)
+			q.readyMutex.Unlock()
+			h.event(Event{Kind: EventBatchBuffered, Size: len(pls), Message: "Batch Push [" + q.Tag + "]: Buffered. Ready Batches: " + strconv.Itoa(ready)})
+			return true
+		}
+		q.readyMutex.Unlock()
+	}
+	go q.worker(b)
+	return true
+}
+
+// worker to run the batch and then the buffered ready batches, releasing its slot in
+// the pool once there is nothing left to run
+func (q *TypedQueue[T]) worker(b batch[T]) {
+	for {
+		q.runBatch(b)
+		q.activeWork.Done()
+		q.readyMutex.Lock()
+		if len(q.ready) == 0 {
+			<-q.workers
+			q.readyMutex.Unlock()
+			return
+		}
+		b, q.ready = q.ready[0], q.ready[1:]
+		q.readyMutex.Unlock()
+		if b.started != nil {
+			close(b.started)
+		}
+	}
+}
+
+// held collects what a call made with payloadMutex held has to do once it is released:
+// the events to emit, so the handlers can call back into the queue, and the batches
+// that OverflowBlock waits for a worker for
+type held struct {
+	events  []Event
+	waiting []chan struct{}
+}
+
+func (h *held) event(e Event) {
+	h.events = append(h.events, e)
+}
+
+// unlock to release payloadMutex, emit the held events and wait for the blocked batches
+func (q *TypedQueue[T]) unlock(h *held) {
+	q.payloadMutex.Unlock()
+	for _, e := range h.events {
+		q.event(e)
+	}
+	for _, started := range h.waiting {
+		<-started
+	}
+}
+
+// runBatch to run the batch and mark it done for FlushAndWait
+func (q *TypedQueue[T]) runBatch(b batch[T]) {
+	q.run(b.key, b.payloads)
+	if b.wait != nil {
+		b.wait.Done()
+	}
+}
+
+// ReadyBatches to return the number of flushed batches waiting for a worker
+func (q *TypedQueue[T]) ReadyBatches() int {
+	q.readyMutex.Lock()
+	defer q.readyMutex.Unlock()
+	return len(q.ready)
+}
@@ -0,0 +1,176 @@
+package payloadqueue_test
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sam-ish/payloadqueue"
+)
+
+// concurrency records the number of Work calls running at once
+type concurrency struct {
+	mutex   sync.Mutex
+	running int
+	max     int
+	batches int
+}
+
+func (c *concurrency) work(pls []interface{}) int {
+	c.mutex.Lock()
+	c.running++
+	c.batches++
+	if c.running > c.max {
+		c.max = c.running
+	}
+	c.mutex.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	c.mutex.Lock()
+	c.running--
+	c.mutex.Unlock()
+	return 0
+}
+
+func TestQueueMaxConcurrentBatches(t *testing.T) {
+	t.Run("Ready batches are buffered", func(t *testing.T) {
+		c := &concurrency{}
+		q := &payloadqueue.Queue{
+			MaxSize:              1,
+			MaxAge:               200,
+			MaxConcurrentBatches: 2,
+			Tag:                  "QueuePool",
+			Work:                 c.work,
+		}
+		q.Start()
+		for i := 0; i < 6; i++ {
+			if err := q.Append(payloadqueue.Payload{Id: strconv.Itoa(i)}); err != nil {
+				t.Errorf("Unexpected error: %s", err.Error())
+			}
+		}
+		if q.ReadyBatches() != 4 {
+			t.Errorf("Expected 4 ready batches, got %d", q.ReadyBatches())
+		}
+		q.Close()
+
+		if c.max != 2 || c.batches != 6 {
+			t.Errorf("Expected 6 batches with at most 2 at once, got %d with %d", c.batches, c.max)
+		}
+	})
+
+	t.Run("Append blocks until a worker is free", func(t *testing.T) {
+		c := &concurrency{}
+		q := &payloadqueue.Queue{
+			MaxSize:              1,
+			MaxAge:               200,
+			MaxConcurrentBatches: 1,
+			Overflow:             payloadqueue.OverflowBlock,
+			Tag:                  "QueuePool",
+			Work:                 c.work,
+		}
+		q.Start()
+		start := time.Now()
+		q.Append(payloadqueue.Payload{Id: "1"})
+		q.Append(payloadqueue.Payload{Id: "2"})
+		if time.Since(start) < 40*time.Millisecond {
+			t.Errorf("Expected the second Append to block")
+		}
+		q.Close()
+		if c.max != 1 {
+			t.Errorf("Expected at most 1 batch at once, got %d", c.max)
+		}
+	})
+
+	t.Run("A blocked Append does not hold the queue", func(t *testing.T) {
+		release := make(chan struct{})
+		q := &payloadqueue.Queue{
+			MaxSize:              1,
+			MaxAge:               200,
+			MaxConcurrentBatches: 1,
+			Overflow:             payloadqueue.OverflowBlock,
+			Tag:                  "QueuePool",
+			Work: func(pls []interface{}) int {
+				<-release
+				return 0
+			},
+		}
+		q.Start()
+		q.Append(payloadqueue.Payload{Id: "1"})
+		appended := make(chan struct{})
+		go func() {
+			q.Append(payloadqueue.Payload{Id: "2"})
+			close(appended)
+		}()
+		time.Sleep(20 * time.Millisecond)
+		sized := make(chan int, 1)
+		go func() { sized <- q.Size() }()
+		select {
+		case <-sized:
+		case <-time.After(time.Second):
+			t.Fatalf("Expected Size not to wait for the blocked Append")
+		}
+		select {
+		case <-appended:
+			t.Errorf("Expected the second Append to block")
+		default:
+		}
+		close(release)
+		<-appended
+		q.Close()
+	})
+
+	t.Run("Event handlers can call back into the queue", func(t *testing.T) {
+		c := &concurrency{}
+		q := &payloadqueue.Queue{
+			MaxSize:              1,
+			MaxAge:               200,
+			MaxConcurrentBatches: 1,
+			Tag:                  "QueuePool",
+			Work:                 c.work,
+		}
+		q.EventFeed = func(string) { q.Size() }
+		q.Start()
+		done := make(chan struct{})
+		go func() {
+			q.Append(payloadqueue.Payload{Id: "1"})
+			q.Append(payloadqueue.Payload{Id: "2"})
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("Expected the buffered event not to deadlock Append")
+		}
+		q.Close()
+	})
+
+	t.Run("Append is rejected when all workers are busy", func(t *testing.T) {
+		c := &concurrency{}
+		q := &payloadqueue.Queue{
+			MaxSize:              2,
+			MaxAge:               200,
+			MaxConcurrentBatches: 1,
+			Overflow:             payloadqueue.OverflowReject,
+			Tag:                  "QueuePool",
+			Work:                 c.work,
+		}
+		q.Start()
+		q.Append(payloadqueue.Payload{Id: "1"})
+		q.Append(payloadqueue.Payload{Id: "2"})
+		q.Append(payloadqueue.Payload{Id: "3"})
+		if err := q.Append(payloadqueue.Payload{Id: "4"}); err == nil {
+			t.Errorf("Expected an error when all workers are busy")
+		}
+		if q.Size() != 1 {
+			t.Errorf("Expected payload 3 to stay buffered, got %d", q.Size())
+		}
+		time.Sleep(100 * time.Millisecond)
+		if err := q.Append(payloadqueue.Payload{Id: "4"}); err != nil {
+			t.Errorf("Unexpected error: %s", err.Error())
+		}
+		q.Close()
+		if c.batches != 2 {
+			t.Errorf("Expected 2 batches, got %d", c.batches)
+		}
+	})
+}
@@ -0,0 +1,125 @@
+// Package prometheus exports the payloadqueue Metrics to Prometheus
+package prometheus
+
+import (
+	"strconv"
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/sam-ish/payloadqueue"
+)
+
+// Collector is a payloadqueue.Metrics that is also a prometheus.Collector.
+// Every metric has a tag label holding the Tag of the queue.
+type Collector struct {
+	enqueued     *prom.CounterVec
+	rejected     *prom.CounterVec
+	flushed      *prom.CounterVec
+	batchSize    *prom.HistogramVec
+	inFlight     *prom.GaugeVec
+	workDuration *prom.HistogramVec
+	results      *prom.CounterVec
+	depth        *prom.GaugeVec
+	rate         *prom.GaugeVec
+}
+
+var _ payloadqueue.Metrics = (*Collector)(nil)
+var _ prom.Collector = (*Collector)(nil)
+
+// NewCollector to create the metrics under namespace. Register it and set it as the
+// Metrics of the queues.
+func NewCollector(namespace string) *Collector {
+	const subsystem = "payloadqueue"
+	return &Collector{
+		enqueued: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace, Subsystem: subsystem, Name: "enqueued_total",
+			Help: "Payloads appended to the queue.",
+		}, []string{"tag"}),
+		rejected: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace, Subsystem: subsystem, Name: "rejected_total",
+			Help: "Payloads refused by Append, by reason.",
+		}, []string{"tag", "reason"}),
+		flushed: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace, Subsystem: subsystem, Name: "flushed_total",
+			Help: "Batches flushed by a Queue, by reason.",
+		}, []string{"tag", "reason"}),
+		batchSize: prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace: namespace, Subsystem: subsystem, Name: "batch_size",
+			Help:    "Payloads in the batches flushed by a Queue.",
+			Buckets: prom.ExponentialBuckets(1, 2, 12),
+		}, []string{"tag"}),
+		inFlight: prom.NewGaugeVec(prom.GaugeOpts{
+			Namespace: namespace, Subsystem: subsystem, Name: "in_flight",
+			Help: "Batches or Work calls running.",
+		}, []string{"tag"}),
+		workDuration: prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace: namespace, Subsystem: subsystem, Name: "work_duration_seconds",
+			Help:    "Time taken by the Work calls.",
+			Buckets: prom.DefBuckets,
+		}, []string{"tag"}),
+		results: prom.NewCounterVec(prom.CounterOpts{
+			Namespace: namespace, Subsystem: subsystem, Name: "results_total",
+			Help: "Payloads worked, by result code.",
+		}, []string{"tag", "code"}),
+		depth: prom.NewGaugeVec(prom.GaugeOpts{
+			Namespace: namespace, Subsystem: subsystem, Name: "depth",
+			Help: "Payloads waiting in the queue.",
+		}, []string{"tag"}),
+		rate: prom.NewGaugeVec(prom.GaugeOpts{
+			Namespace: namespace, Subsystem: subsystem, Name: "rate",
+			Help: "Effective rate of a RateQueue, in requests per Per.",
+		}, []string{"tag"}),
+	}
+}
+
+// collectors to list the metrics of the Collector
+func (c *Collector) collectors() []prom.Collector {
+	return []prom.Collector{c.enqueued, c.rejected, c.flushed, c.batchSize, c.inFlight, c.workDuration, c.results, c.depth, c.rate}
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prom.Desc) {
+	for _, m := range c.collectors() {
+		m.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prom.Metric) {
+	for _, m := range c.collectors() {
+		m.Collect(ch)
+	}
+}
+
+func (c *Collector) Enqueued(tag string) {
+	c.enqueued.WithLabelValues(tag).Inc()
+}
+
+func (c *Collector) Rejected(tag string, reason string) {
+	c.rejected.WithLabelValues(tag, reason).Inc()
+}
+
+func (c *Collector) Flushed(tag string, reason payloadqueue.FlushReason, size int) {
+	c.flushed.WithLabelValues(tag, reason.String()).Inc()
+	c.batchSize.WithLabelValues(tag).Observe(float64(size))
+}
+
+func (c *Collector) InFlight(tag string, n int) {
+	c.inFlight.WithLabelValues(tag).Set(float64(n))
+}
+
+func (c *Collector) WorkDuration(tag string, d time.Duration) {
+	c.workDuration.WithLabelValues(tag).Observe(d.Seconds())
+}
+
+func (c *Collector) Results(tag string, code int, n int) {
+	c.results.WithLabelValues(tag, strconv.Itoa(code)).Add(float64(n))
+}
+
+func (c *Collector) Depth(tag string, n int) {
+	c.depth.WithLabelValues(tag).Set(float64(n))
+}
+
+func (c *Collector) Rate(tag string, rate float64) {
+	c.rate.WithLabelValues(tag).Set(rate)
+}
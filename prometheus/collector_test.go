@@ -0,0 +1,77 @@
+package prometheus_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/sam-ish/payloadqueue"
+	"github.com/sam-ish/payloadqueue/prometheus"
+)
+
+func TestCollector(t *testing.T) {
+	c := prometheus.NewCollector("test")
+	reg := prom.NewRegistry()
+	if err := reg.Register(c); err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	q := &payloadqueue.Queue{
+		MaxSize: 2,
+		MaxAge:  1000,
+		Tag:     "Collector",
+		Metrics: c,
+		Work:    func(pls []interface{}) int { return 0 },
+	}
+	q.Start()
+	q.Append(payloadqueue.Payload{Id: "1", Data: 1})
+	q.Append(payloadqueue.Payload{Id: "2", Data: 2})
+	time.Sleep(50 * time.Millisecond)
+	q.Close()
+	q.Append(payloadqueue.Payload{Id: "3", Data: 3})
+
+	expected := `
+# HELP test_payloadqueue_enqueued_total Payloads appended to the queue.
+# TYPE test_payloadqueue_enqueued_total counter
+test_payloadqueue_enqueued_total{tag="Collector"} 2
+# HELP test_payloadqueue_flushed_total Batches flushed by a Queue, by reason.
+# TYPE test_payloadqueue_flushed_total counter
+test_payloadqueue_flushed_total{reason="size",tag="Collector"} 1
+# HELP test_payloadqueue_rejected_total Payloads refused by Append, by reason.
+# TYPE test_payloadqueue_rejected_total counter
+test_payloadqueue_rejected_total{reason="closed",tag="Collector"} 1
+# HELP test_payloadqueue_results_total Payloads worked, by result code.
+# TYPE test_payloadqueue_results_total counter
+test_payloadqueue_results_total{code="0",tag="Collector"} 2
+`
+	err := testutil.GatherAndCompare(reg, strings.NewReader(expected),
+		"test_payloadqueue_enqueued_total", "test_payloadqueue_flushed_total",
+		"test_payloadqueue_rejected_total", "test_payloadqueue_results_total")
+	if err != nil {
+		t.Error(err)
+	}
+	if n := testutil.CollectAndCount(c, "test_payloadqueue_work_duration_seconds"); n != 1 {
+		t.Errorf("Expected a work duration for the tag, got %d", n)
+	}
+}
+
+func TestCollectorRate(t *testing.T) {
+	c := prometheus.NewCollector("test")
+	q := &payloadqueue.RateQueue{
+		Rate:    50,
+		Tag:     "CollectorRate",
+		Metrics: c,
+		Work:    func(pl interface{}) int { return 0 },
+	}
+	q.Start()
+	defer q.Close()
+	expected := `
+# HELP test_payloadqueue_rate Effective rate of a RateQueue, in requests per Per.
+# TYPE test_payloadqueue_rate gauge
+test_payloadqueue_rate{tag="CollectorRate"} 50
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected), "test_payloadqueue_rate"); err != nil {
+		t.Error(err)
+	}
+}
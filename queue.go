@@ -1,157 +1,552 @@
 package payloadqueue
 
 import (
+	"context"
 	"errors"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
-
-	"github.com/google/uuid"
 )
 
-// Queue to hold the main application queuing mechanism.
-type Queue struct {
-	Tag          string
-	MaxSize      int
-	MaxAge       int // seconds
-	Work         workHandler
-	EventFeed    eventFeed
-	payloadMutex sync.Mutex
-	payloadQueue []Payload
-	payloadChan  chan Payload
-	quitChan     chan bool
-	expires      time.Time
-	activeWork   int // holds the number of active work routines that have not been completed.
+// TypedQueue to hold the main application queuing mechanism. Payloads of type T
+// are batched and handed to Work as a []T once the queue is full or expired.
+type TypedQueue[T any] struct {
+	Tag                  string
+	MaxSize              int
+	MaxAge               int // seconds
+	Work                 workHandler[T]
+	WorkContext          workContextHandler[T]      // used instead of Work when supplied. The context is cancelled by CloseContext.
+	WorkEach             batchWorkHandler[T]        // used instead of Work when supplied. Returns a result per item.
+	WorkEachContext      batchWorkContextHandler[T] // used instead of WorkEach when supplied
+	WorkKeyed            keyedWorkHandler[T]        // used instead of Work when supplied. Receives the partition key.
+	KeyFunc              func(T) string             // routes the payloads into partitions batched independently
+	MaxPartitions        int                        // 0 is unlimited
+	PartitionIdle        time.Duration              // empty partitions are evicted after this. Default is 5 minutes
+	MaxBytes             int                        // a batch is flushed before it exceeds this size. 0 is unlimited
+	SizeFunc             func(T) int                // size of an item in bytes. Default is its encoded length
+	SendOversized        bool                       // a payload larger than MaxBytes is sent alone instead of rejected
+	MaxConcurrentBatches int                        // 0 is unlimited
+	Overflow             OverflowPolicy             // used when MaxConcurrentBatches are running. Default is OverflowBuffer
+	EventFeed            eventFeed
+	Events               EventHandler       // receives the typed events. EventFeed receives them as strings
+	Metrics              Metrics            // receives the measurements of the queue when supplied
+	Tracer               Tracer             // starts a span per batch, linked to the spans given to AppendContext
+	FlushSignal          <-chan struct{}    // the buffered payloads are flushed on each receive
+	Clock                Clock              // the source of time. Default is the system clock
+	Retry                RetryPolicy        // failed payloads are appended again when the policy allows it
+	DeadLetter           TypedDeadLetter[T] // receives the payloads that are not retried
+	WAL                  *TypedWAL[T]       // payloads are logged to disk and replayed by Start when supplied
+	Breaker              *CircuitBreaker    // the payloads are held in the queue while it is open
+	payloadMutex         sync.Mutex
+	partitions           map[string]*partition[T]
+	closed               bool // set by CloseContext. Append is refused once closed
+	poolOnce             sync.Once
+	workers              chan struct{} // a slot per running batch when MaxConcurrentBatches is set
+	readyMutex           sync.Mutex
+	ready                []batch[T]  // flushed batches waiting for a worker
+	schedule             schedule[T] // payloads held until their NotBefore
+	quitChan             chan bool
+	closeOnce            sync.Once
+	ctx                  context.Context // passed to WorkContext
+	cancel               context.CancelFunc
+	activeWork           sync.WaitGroup  // holds the active work routines that have not been completed.
+	running              int64           // batches in Work, for Metrics.InFlight
+	flushWait            *sync.WaitGroup // set by FlushAndWait while it flushes. Guarded by payloadMutex
 }
 
+// Queue is the interface{}-based TypedQueue. Work receives the batch as []interface{}.
+type Queue = TypedQueue[interface{}]
+
 // Start to open the queue to receive payload to batch
-func (q *Queue) Start() error {
-	q.expires = time.Now().Add(time.Duration(q.MaxAge) * time.Second)
-	if q.Work == nil {
+func (q *TypedQueue[T]) Start() error {
+	return q.StartContext(context.Background())
+}
+
+// StartContext to open the queue to receive payload to batch. The queue is closed
+// when ctx is cancelled.
+func (q *TypedQueue[T]) StartContext(ctx context.Context) error {
+	if !q.hasWork() {
 		return errors.New("the Work function is not supplied")
 	}
 	if q.MaxSize == 0 {
 		q.MaxSize = 100
-		q.event("MaxSize: Default value of 100 was used")
+		q.event(Event{Kind: EventDefault, Message: "MaxSize: Default value of 100 was used"})
 	}
 	if q.MaxAge == 0 {
 		q.MaxAge = 10
-		q.event("MaxAge: Default value of 10 was used")
+		q.event(Event{Kind: EventDefault, Message: "MaxAge: Default value of 10 was used"})
 	}
 	if q.Tag == "" {
 		q.Tag = defaultTag(12)
-		q.event("Tag: Random value assigned is: " + q.Tag)
+		q.event(Event{Kind: EventDefault, Message: "Tag: Random value assigned is: " + q.Tag})
 	}
-	q.activeWork = 0
-
-	go func() {
-		// Check for the max age
-		for {
-			time.Sleep(2 * time.Second)
-			if time.Now().After(q.expires) {
-				q.Append(Payload{})
-			}
+	var replay []TypedPayload[T]
+	if q.WAL != nil {
+		var err error
+		if replay, err = q.WAL.Open(); err != nil {
+			return err
 		}
-	}()
+	}
+	q.ctx, q.cancel = context.WithCancel(context.Background())
+	q.quitChan = make(chan bool)
+	q.schedule.clock = q.Clock
+	if q.Breaker != nil && q.Breaker.Clock == nil {
+		q.Breaker.Clock = q.Clock
+	}
 
+	ticker := q.clock().NewTicker(2 * time.Second)
+	flushSignal := q.FlushSignal
 	go func() {
+		defer ticker.Stop()
 		for {
 			select {
-			case p := <-q.payloadChan:
-				// Payload has been added for queuing
-				q.Append(p)
+			case <-ticker.C():
+				// Check for the max age
+				var h held
+				q.payloadMutex.Lock()
+				q.flushDue(&h)
+				q.unlock(&h)
+
+			case _, ok := <-flushSignal:
+				if !ok {
+					// a closed channel would be ready on every select
+					flushSignal = nil
+					continue
+				}
+				q.Flush()
+
+			case <-ctx.Done():
+				// The parent context is done.
+				q.Close()
+				return
 
 			case <-q.quitChan:
 				// We have been asked to stop.
-				q.Close()
 				return
 			}
 		}
 	}()
-	q.event("BP Queue: Started")
+	q.event(Event{Kind: EventStarted, Message: "BP Queue: Started"})
+	if len(replay) > 0 {
+		q.event(Event{Kind: EventWAL, Size: len(replay), Message: "WAL: Replaying " + strconv.Itoa(len(replay)) + " payload(s)"})
+		for _, p := range replay {
+			q.Append(p)
+		}
+	}
 	return nil
 }
 
-func (q Queue) NewPayload(pl interface{}) Payload {
-	if pl == nil {
-		return Payload{
-			Id:   "",
-			Data: "",
-		}
+// NewPayload to wrap the data into a payload for this queue
+func (q *TypedQueue[T]) NewPayload(pl T) TypedPayload[T] {
+	return NewPayload(pl)
+}
+
+// Run to push the Batch for processing. With a KeyFunc, the partition key is taken
+// from the first payload.
+func (q *TypedQueue[T]) Run(Payloads []TypedPayload[T]) error {
+	key := ""
+	if len(Payloads) > 0 {
+		key = q.key(Payloads[0].Data)
 	}
-	u := uuid.New()
-	return Payload{
-		Id:   u.String(),
-		Data: pl,
+	q.payloadMutex.Lock()
+	if q.closed {
+		q.payloadMutex.Unlock()
+		return errClosed
+	}
+	allowed, changed := q.allow()
+	if !allowed {
+		q.payloadMutex.Unlock()
+		q.breakerChanged(changed)
+		return errBreakerOpen
 	}
+	q.activeWork.Add(1)
+	q.payloadMutex.Unlock()
+	q.breakerChanged(changed)
+	defer q.activeWork.Done()
+	return q.run(key, Payloads)
 }
 
-// Run to push the Batch for processing
-func (q *Queue) Run(Payloads []Payload) error {
-	if q.Work == nil {
+// run to push the Batch of the partition key for processing
+func (q *TypedQueue[T]) run(key string, Payloads []TypedPayload[T]) error {
+	if !q.hasWork() {
 		return errors.New("no Work() is passed")
 	}
-	q.event("Batch Push [" + q.Tag + "]: Running. Queue Size: " + strconv.Itoa(len(Payloads)) + " @ " + time.Now().String())
-	q.activeWork++
-	pl := make([]interface{}, 0)
-	for _, v := range Payloads {
-		pl = append(pl, v.Data)
+	ids := make([]string, 0, len(Payloads))
+	for _, p := range Payloads {
+		ids = append(ids, p.Id)
+	}
+	now := q.clock().Now()
+	q.event(Event{Kind: EventBatchRunning, Level: LevelDebug, Ids: ids, Size: len(Payloads), Message: "Batch Push [" + q.Tag + "]: Running. Queue Size: " + strconv.Itoa(len(Payloads)) + " @ " + now.String()})
+	pl := make([]T, 0, len(Payloads))
+	for i := range Payloads {
+		Payloads[i].Attempts++
+		Payloads[i].LastAttempt = now
+		pl = append(pl, Payloads[i].Data)
+	}
+	ctx, end := q.trace(Payloads)
+	q.metrics().InFlight(q.Tag, int(atomic.AddInt64(&q.running, 1)))
+	results := q.work(ctx, key, pl)
+	end(results)
+	q.metrics().WorkDuration(q.Tag, q.clock().Now().Sub(now))
+	q.metrics().InFlight(q.Tag, int(atomic.AddInt64(&q.running, -1)))
+	q.measureResults(results)
+	var done, failed []TypedPayload[T]
+	for i := range Payloads {
+		Payloads[i].Result = results[i]
+		if results[i] != 0 {
+			failed = append(failed, Payloads[i])
+		} else {
+			done = append(done, Payloads[i])
+		}
+	}
+	if q.WorkEach != nil || q.WorkEachContext != nil {
+		q.event(Event{Kind: EventBatchFinished, Ids: ids, Size: len(Payloads), Duration: q.clock().Now().Sub(now), Message: "Batch Push [" + q.Tag + "]: Finished. Failed: " + strconv.Itoa(len(failed)) + " of " + strconv.Itoa(len(Payloads)) + " @ " + q.clock().Now().String()})
+	} else {
+		result := 0
+		if len(failed) > 0 {
+			result = failed[0].Result
+		}
+		q.event(Event{Kind: EventBatchFinished, Ids: ids, Size: len(Payloads), Result: result, Duration: q.clock().Now().Sub(now), Message: "Batch Push [" + q.Tag + "]: Finished. Result Code: " + strconv.Itoa(result) + " @ " + q.clock().Now().String()})
+	}
+	q.record(len(failed) == 0 || len(done) > 0)
+	if len(done) > 0 {
+		q.ack(done...)
 	}
-	result := q.Work(pl)
-	q.event("Batch Push [" + q.Tag + "]: Finished. Result Code: " + strconv.Itoa(result) + " @ " + time.Now().String())
-	q.activeWork--
+	q.retry(failed)
 
 	return nil
 }
 
-// Append to add a Payload to the queue. This is a
-func (q *Queue) Append(p Payload) error {
-	// Add to the queue
-	if p.Id != "" {
-		q.payloadMutex.Lock()
-		q.payloadQueue = append(q.payloadQueue, p)
-		q.payloadMutex.Unlock()
-		q.event("Payload Queued [id]: " + p.Id)
+// hasWork to check that one of the Work handlers is supplied
+func (q *TypedQueue[T]) hasWork() bool {
+	return q.Work != nil || q.WorkContext != nil || q.WorkEach != nil || q.WorkEachContext != nil || q.WorkKeyed != nil
+}
+
+// work to call the supplied Work handler with the batch and return a result per item.
+// Items missing from a WorkEach result are treated as failed with -1.
+func (q *TypedQueue[T]) work(ctx context.Context, key string, pl []T) []int {
+	results := make([]int, len(pl))
+	var each []int
+	switch {
+	case q.WorkEachContext != nil:
+		each = q.WorkEachContext(ctx, pl)
+	case q.WorkEach != nil:
+		each = q.WorkEach(pl)
+	default:
+		result := 0
+		switch {
+		case q.WorkContext != nil:
+			result = q.WorkContext(ctx, pl)
+		case q.WorkKeyed != nil:
+			result = q.WorkKeyed(key, pl)
+		default:
+			result = q.Work(pl)
+		}
+		for i := range results {
+			results[i] = result
+		}
+		return results
 	}
-	// Check the conditions for firing the Work()
-	// 1. Queue is full
-	// 2. MaxAge has expired
-	if len(q.payloadQueue) >= q.MaxSize || time.Now().After(q.expires) {
-		q.payloadMutex.Lock()
-		pls := q.payloadQueue
-		go q.Run(pls)
-		// reset the queue
-		q.payloadQueue = nil
+	if len(each) != len(pl) {
+		q.event(Event{Kind: EventBatchFinished, Level: LevelWarn, Size: len(pl), Message: "Batch Push [" + q.Tag + "]: " + strconv.Itoa(len(each)) + " result(s) returned for " + strconv.Itoa(len(pl)) + " item(s)"})
+	}
+	for i := range results {
+		results[i] = -1
+		if i < len(each) {
+			results[i] = each[i]
+		}
+	}
+	return results
+}
+
+// retry to schedule the failed payloads again once the RetryPolicy backoff has elapsed,
+// so the retries pending at Close are kept like the scheduled payloads. Payloads that
+// are not retried are sent to the DeadLetter.
+func (q *TypedQueue[T]) retry(pls []TypedPayload[T]) {
+	for _, p := range pls {
+		var delay time.Duration
+		ok := false
+		if q.Retry != nil {
+			delay, ok = q.Retry.Retry(p.Attempts, p.Result)
+		}
+		if !ok {
+			q.event(Event{Kind: EventFailed, Level: LevelError, Ids: []string{p.Id}, Result: p.Result, Message: "Payload Failed [id]: " + p.Id + ". Result Code: " + strconv.Itoa(p.Result) + " after " + strconv.Itoa(p.Attempts) + " attempt(s)"})
+			q.deadLetter(p)
+			q.ack(p)
+			continue
+		}
+		q.event(Event{Kind: EventRetry, Level: LevelWarn, Ids: []string{p.Id}, Result: p.Result, Duration: delay, Message: "Payload Retry [id]: " + p.Id + ". Attempt " + strconv.Itoa(p.Attempts+1) + " in " + delay.String()})
+		switch err := q.AppendAfter(p, delay); err {
+		case nil:
+		case errClosed:
+			q.unschedule([]TypedPayload[T]{p})
+		default:
+			q.deadLetter(p)
+			q.ack(p)
+		}
+	}
+}
+
+// deadLetter to hand the failed payload to the DeadLetter, if any
+func (q *TypedQueue[T]) deadLetter(p TypedPayload[T]) {
+	if q.DeadLetter == nil {
+		return
+	}
+	if err := q.DeadLetter.Add(p); err != nil {
+		q.event(Event{Kind: EventDeadLetter, Level: LevelError, Ids: []string{p.Id}, Err: err, Message: "Dead Letter [id]: " + p.Id + " failed. " + err.Error()})
+		return
+	}
+	q.event(Event{Kind: EventDeadLetter, Level: LevelWarn, Ids: []string{p.Id}, Message: "Dead Letter [id]: " + p.Id})
+}
+
+// ack to remove the completed payloads from the WAL, if any
+func (q *TypedQueue[T]) ack(pls ...TypedPayload[T]) {
+	if q.WAL == nil {
+		return
+	}
+	ids := make([]string, 0, len(pls))
+	for _, p := range pls {
+		ids = append(ids, p.Id)
+	}
+	if err := q.WAL.Ack(ids...); err != nil {
+		q.event(Event{Kind: EventWAL, Level: LevelError, Ids: ids, Err: err, Message: "WAL: Ack failed. " + err.Error()})
+	}
+}
+
+// Redrive to drain the dead letter and append its payloads back into the queue.
+// The number of payloads redriven is returned.
+func (q *TypedQueue[T]) Redrive(d TypedDeadLetter[T]) (int, error) {
+	return redrive[T](d, q)
+}
+
+// Append to add a Payload to the queue. An empty payload flushes the expired partitions.
+func (q *TypedQueue[T]) Append(p TypedPayload[T]) error {
+	return q.add(p, false)
+}
+
+// add to append the payload. A due payload comes from the schedule and was already
+// counted as enqueued by AppendAt.
+func (q *TypedQueue[T]) add(p TypedPayload[T], due bool) error {
+	q.payloadMutex.Lock()
+	if q.closed {
 		q.payloadMutex.Unlock()
-		q.expires = time.Now().Add(time.Duration(q.MaxAge) * time.Second)
+		if p.Id != "" {
+			q.metrics().Rejected(q.Tag, RejectedClosed)
+			q.event(Event{Kind: EventAppendFailed, Level: LevelWarn, Ids: []string{p.Id}, Err: errClosed, Message: "Payload " + p.Id + " failed. " + errClosed.Error()})
+		}
+		return errClosed
 	}
+	var h held
+	if p.Id == "" {
+		// An empty payload checks every partition
+		q.flushDue(&h)
+		q.unlock(&h)
+		return nil
+	}
+	scheduled, err := q.append(&h, p)
+	if err == nil {
+		if !due {
+			q.metrics().Enqueued(q.Tag)
+		}
+		q.measureDepth()
+	}
+	q.unlock(&h)
+	if err == errBusy && !due {
+		// the caller gets the error, so the payload is not replayed by the WAL
+		q.ack(p)
+	}
+	if err != nil {
+		q.event(Event{Kind: EventAppendFailed, Level: LevelWarn, Ids: []string{p.Id}, Err: err, Message: "Payload " + p.Id + " failed. " + err.Error()})
+		return err
+	}
+	if scheduled {
+		q.event(Event{Kind: EventScheduled, Level: LevelDebug, Ids: []string{p.Id}, Message: "Payload Scheduled [id]: " + p.Id + " @ " + p.NotBefore.String()})
+		return nil
+	}
+	q.event(Event{Kind: EventQueued, Level: LevelDebug, Ids: []string{p.Id}, Message: "Payload Queued [id]: " + p.Id})
 	return nil
 }
 
-// Close to close the channels and wait for Work funcs to quit the execution.
-func (q *Queue) Close() {
-	q.event("Buffer Queue: Stopping...")
-	if q.payloadChan != nil {
-		close(q.payloadChan)
+// append to add the payload to its partition and flush the partition when due. It
+// returns true when the payload is scheduled for its NotBefore instead.
+// Must be called with payloadMutex held.
+func (q *TypedQueue[T]) append(h *held, p TypedPayload[T]) (bool, error) {
+	if p.Queued.IsZero() {
+		p.Queued = q.clock().Now()
+	}
+	size := q.size(h, p.Data)
+	oversized := q.MaxBytes > 0 && size > q.MaxBytes
+	if oversized && !q.SendOversized {
+		q.metrics().Rejected(q.Tag, RejectedTooLarge)
+		return false, errors.New("Size of " + strconv.Itoa(size) + " bytes exceeds MaxBytes of " + strconv.Itoa(q.MaxBytes))
+	}
+	if q.WAL != nil {
+		if err := q.WAL.Append(p); err != nil {
+			q.metrics().Rejected(q.Tag, RejectedWAL)
+			return false, err
+		}
+	}
+	if p.NotBefore.After(q.clock().Now()) {
+		if !q.schedule.push(p, q.due) {
+			q.metrics().Rejected(q.Tag, RejectedClosed)
+			return false, errClosed
+		}
+		return true, nil
+	}
+	key := q.key(p.Data)
+	if oversized {
+		allowed, changed := q.allow()
+		h.breakerChanged(changed)
+		if allowed {
+			h.event(Event{Kind: EventOversized, Ids: []string{p.Id}, Size: size, Message: "Payload Oversized [id]: " + p.Id + ". Size of " + strconv.Itoa(size) + " bytes is sent alone"})
+			if !q.dispatch(h, key, []TypedPayload[T]{p}) {
+				if q.Breaker != nil {
+					q.Breaker.release()
+				}
+				return false, q.rejected(p)
+			}
+			q.metrics().Flushed(q.Tag, FlushOversized, 1)
+			return false, nil
+		}
+	}
+	// an oversized payload held by the Breaker is sent alone by flush later on
+	pt, err := q.partition(h, key)
+	if err != nil {
+		return false, q.rejected(p)
+	}
+	if q.MaxBytes > 0 && pt.bytes+size > q.MaxBytes {
+		// flush first so the batch stays within MaxBytes
+		if err := q.flush(h, key, pt, FlushBytes); err != nil && err != errBreakerOpen {
+			return false, q.rejected(p)
+		}
+	}
+	if len(pt.payloads) == 0 {
+		pt.expires = q.clock().Now().Add(time.Duration(q.MaxAge) * time.Second)
+	}
+	pt.payloads = append(pt.payloads, p)
+	pt.bytes += size
+	pt.lastUsed = q.clock().Now()
+	// Check the conditions for firing the Work()
+	// 1. Partition is full
+	// 2. MaxBytes is reached
+	// 3. MaxAge has expired
+	reason := FlushAge
+	switch {
+	case len(pt.payloads) >= q.MaxSize:
+		reason = FlushSize
+	case q.MaxBytes > 0 && pt.bytes >= q.MaxBytes:
+		reason = FlushBytes
+	}
+	if reason != FlushAge || q.clock().Now().After(pt.expires) {
+		if err := q.flush(h, key, pt, reason); err != nil && err != errBreakerOpen {
+			// the batch stays buffered without the new payload
+			pt.payloads = pt.payloads[:len(pt.payloads)-1]
+			pt.bytes -= size
+			return false, q.rejected(p)
+		}
+	}
+	return false, nil
+}
+
+// rejected to count the payload refused by OverflowReject. add removes it from the WAL
+// unless it comes from the schedule.
+func (q *TypedQueue[T]) rejected(p TypedPayload[T]) error {
+	q.metrics().Rejected(q.Tag, RejectedBusy)
+	return errBusy
+}
+
+// size to return the size of the data in bytes when MaxBytes is set. Must be called with
+// payloadMutex held.
+func (q *TypedQueue[T]) size(h *held, data T) int {
+	if q.MaxBytes == 0 {
+		return 0
+	}
+	if q.SizeFunc != nil {
+		return q.SizeFunc(data)
 	}
-	if q.quitChan != nil {
-		close(q.quitChan)
+	var codec Codec[T] = JSONCodec[T]{}
+	if q.WAL != nil && q.WAL.Codec != nil {
+		codec = q.WAL.Codec
 	}
+	b, err := codec.Encode(data)
+	if err != nil {
+		h.event(Event{Kind: EventAppendFailed, Level: LevelError, Err: err, Message: "SizeFunc: Data cannot be encoded. " + err.Error()})
+		return 0
+	}
+	return len(b)
+}
+
+// Close to close the channels and wait for Work funcs to quit the execution.
+func (q *TypedQueue[T]) Close() {
+	q.CloseContext(context.Background())
+}
+
+// CloseContext to flush the buffered payloads, close the channels and wait for Work
+// funcs to quit the execution. Payloads held by an open Breaker are left in the queue.
+// If ctx is done before all Work has completed, the context passed to WorkContext
+// is cancelled and ctx.Err() is returned.
+func (q *TypedQueue[T]) CloseContext(ctx context.Context) error {
+	q.event(Event{Kind: EventStopping, Message: "Buffer Queue: Stopping..."})
+	q.closeOnce.Do(func() {
+		if q.quitChan != nil {
+			close(q.quitChan)
+		}
+		q.closeSchedule()
+		if err := q.flushAll(nil, true); err != nil {
+			q.event(Event{Kind: EventPending, Level: LevelWarn, Size: q.Size(), Err: err, Message: "Buffer Queue: " + strconv.Itoa(q.Size()) + " payload(s) left in the queue. " + err.Error()})
+		}
+	})
 	// wait for all active routines to be completed
-	for q.activeWork > 0 {
-		time.Sleep(time.Second * 1)
+	done := make(chan bool)
+	go func() {
+		q.activeWork.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if q.cancel != nil {
+		q.cancel()
+	}
+	if q.WAL != nil {
+		q.WAL.Close()
 	}
-	q.event("Buffer Queue: All Work completed")
+	if err != nil {
+		q.event(Event{Kind: EventStopped, Level: LevelWarn, Err: err, Message: "Buffer Queue: Shutdown deadline passed. Active Work cancelled"})
+		return err
+	}
+	q.event(Event{Kind: EventStopped, Message: "Buffer Queue: All Work completed"})
+	return nil
 }
 
-// event to write events into the Queue's feed
-func (q *Queue) event(s string) {
+// event to write events into the Queue's feeds
+func (q *TypedQueue[T]) event(e Event) {
+	if q.Events == nil && q.EventFeed == nil {
+		return
+	}
+	e.Tag = q.Tag
+	if e.Time.IsZero() {
+		e.Time = q.clock().Now()
+	}
+	if q.Events != nil {
+		q.Events(e)
+	}
 	if q.EventFeed != nil {
-		q.EventFeed("[" + q.Tag + "] " + s)
+		q.EventFeed(e.String())
 	}
 }
 
 // Size to return the number of payloads in the queue
-func (q *Queue) Size() int {
-	return len(q.payloadQueue)
+func (q *TypedQueue[T]) Size() int {
+	q.payloadMutex.Lock()
+	defer q.payloadMutex.Unlock()
+	size := 0
+	for _, pt := range q.partitions {
+		size += len(pt.payloads)
+	}
+	return size
 }
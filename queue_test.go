@@ -1,6 +1,8 @@
 package payloadqueue_test
 
 import (
+	"context"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -88,9 +90,11 @@ func TestQueueRun(t *testing.T) {
 		// Delay is important to be sure that the Work() goroutine has been called before the assertion
 		time.Sleep(1 * time.Second)
 		// Ensure that Run was triggered and the queue was reset
+		runMutex.Lock()
 		if runtimes != 2 {
 			t.Errorf("Expected runtimes to be 2, got %d", runtimes)
 		}
+		runMutex.Unlock()
 		q.Close()
 	})
 
@@ -176,3 +180,349 @@ func TestQueueAppend(t *testing.T) {
 		q.Close()
 	})
 }
+
+func TestTypedQueue(t *testing.T) {
+	type job struct {
+		Name string
+	}
+
+	t.Run("Work receives typed batch", func(t *testing.T) {
+		var runMutex sync.Mutex
+		names := make([]string, 0)
+
+		q := &payloadqueue.TypedQueue[job]{
+			MaxSize: 2,
+			MaxAge:  200,
+			Tag:     "TypedQueue",
+			Work: func(jobs []job) int {
+				runMutex.Lock()
+				for _, j := range jobs {
+					names = append(names, j.Name)
+				}
+				runMutex.Unlock()
+				return 0
+			},
+		}
+		q.Start()
+		q.Append(q.NewPayload(job{Name: "Alpha"}))
+		q.Append(q.NewPayload(job{Name: "Beta"}))
+
+		time.Sleep(500 * time.Millisecond)
+		runMutex.Lock()
+		if len(names) != 2 || names[0] != "Alpha" || names[1] != "Beta" {
+			t.Errorf("Expected [Alpha Beta], got %v", names)
+		}
+		runMutex.Unlock()
+		q.Close()
+	})
+
+	t.Run("NewPayload keeps the type", func(t *testing.T) {
+		pl := payloadqueue.NewPayload(job{Name: "Alpha"})
+		if pl.Id == "" {
+			t.Errorf("Expected a payload Id")
+		}
+		if pl.Data.Name != "Alpha" {
+			t.Errorf("Expected Alpha, got %s", pl.Data.Name)
+		}
+	})
+
+	t.Run("NewPayload with nil data", func(t *testing.T) {
+		if pl := payloadqueue.NewPayload[interface{}](nil); pl.Id != "" {
+			t.Errorf("Expected an empty payload Id, got %s", pl.Id)
+		}
+	})
+}
+
+func TestQueueContext(t *testing.T) {
+	t.Run("CloseContext cancels active WorkContext", func(t *testing.T) {
+		cancelled := make(chan bool, 1)
+		q := &payloadqueue.Queue{
+			MaxSize: 1,
+			MaxAge:  200,
+			Tag:     "QueueCtx",
+			WorkContext: func(ctx context.Context, pls []interface{}) int {
+				<-ctx.Done()
+				cancelled <- true
+				return 1
+			},
+		}
+		q.Start()
+		q.Append(payloadqueue.Payload{Id: "1"})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		if err := q.CloseContext(ctx); err != context.DeadlineExceeded {
+			t.Errorf("Expected DeadlineExceeded, got %v", err)
+		}
+		select {
+		case <-cancelled:
+		case <-time.After(time.Second):
+			t.Errorf("Expected WorkContext to be cancelled")
+		}
+	})
+
+	t.Run("CloseContext waits for Work", func(t *testing.T) {
+		q := &payloadqueue.Queue{
+			MaxSize: 1,
+			MaxAge:  200,
+			Tag:     "QueueCtx",
+			WorkContext: func(ctx context.Context, pls []interface{}) int {
+				time.Sleep(100 * time.Millisecond)
+				return 0
+			},
+		}
+		q.Start()
+		q.Append(payloadqueue.Payload{Id: "1"})
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := q.CloseContext(ctx); err != nil {
+			t.Errorf("Unexpected error: %s", err.Error())
+		}
+	})
+
+	t.Run("StartContext closes on cancel", func(t *testing.T) {
+		var feedMutex sync.Mutex
+		closed := false
+		ctx, cancel := context.WithCancel(context.Background())
+		q := &payloadqueue.Queue{
+			Tag:  "QueueCtx",
+			Work: func(pls []interface{}) int { return 0 },
+			EventFeed: func(s string) {
+				feedMutex.Lock()
+				if strings.HasSuffix(s, "All Work completed") {
+					closed = true
+				}
+				feedMutex.Unlock()
+			},
+		}
+		if err := q.StartContext(ctx); err != nil {
+			t.Errorf("Unexpected error: %s", err.Error())
+		}
+		cancel()
+		time.Sleep(200 * time.Millisecond)
+		feedMutex.Lock()
+		if !closed {
+			t.Errorf("Expected the queue to be closed")
+		}
+		feedMutex.Unlock()
+	})
+}
+
+func TestQueueRetry(t *testing.T) {
+	t.Run("Failed batch is retried until it succeeds", func(t *testing.T) {
+		var runMutex sync.Mutex
+		runtimes := 0
+
+		q := &payloadqueue.Queue{
+			MaxSize: 2,
+			MaxAge:  200,
+			Tag:     "QueueRetry",
+			Retry: payloadqueue.ExponentialBackoff{
+				MaxAttempts:  3,
+				InitialDelay: 50 * time.Millisecond,
+			},
+			Work: func(pls []interface{}) int {
+				runMutex.Lock()
+				defer runMutex.Unlock()
+				runtimes += 1
+				if runtimes < 3 {
+					return 1
+				}
+				return 0
+			},
+		}
+		q.Start()
+		q.Append(payloadqueue.Payload{Id: "1"})
+		q.Append(payloadqueue.Payload{Id: "2"})
+
+		time.Sleep(500 * time.Millisecond)
+		runMutex.Lock()
+		if runtimes != 3 {
+			t.Errorf("Expected runtimes to be 3, got %d", runtimes)
+		}
+		runMutex.Unlock()
+		q.Close()
+	})
+
+	t.Run("Attempts are tracked on the Payload", func(t *testing.T) {
+		q := &payloadqueue.Queue{
+			Tag:   "QueueRetry",
+			Retry: payloadqueue.ExponentialBackoff{MaxAttempts: 1},
+			Work:  func(pls []interface{}) int { return 1 },
+		}
+		pls := []payloadqueue.Payload{{Id: "1"}, {Id: "2", Attempts: 2}}
+		q.Run(pls)
+		if pls[0].Attempts != 1 || pls[1].Attempts != 3 {
+			t.Errorf("Expected attempts to be 1 and 3, got %d and %d", pls[0].Attempts, pls[1].Attempts)
+		}
+	})
+}
+
+func TestQueueWorkEach(t *testing.T) {
+	t.Run("Only the failed items are retried", func(t *testing.T) {
+		var runMutex sync.Mutex
+		var batches [][]interface{}
+
+		dl := &payloadqueue.MemoryDeadLetter[interface{}]{}
+		q := &payloadqueue.Queue{
+			MaxSize:    3,
+			MaxAge:     10,
+			Tag:        "QueueWorkEach",
+			Retry:      payloadqueue.ExponentialBackoff{MaxAttempts: 2, InitialDelay: 10 * time.Millisecond},
+			DeadLetter: dl,
+			WorkEach: func(pls []interface{}) []int {
+				runMutex.Lock()
+				defer runMutex.Unlock()
+				batches = append(batches, pls)
+				results := make([]int, len(pls))
+				for i, pl := range pls {
+					if pl == "b" {
+						results[i] = 500
+					}
+				}
+				return results
+			},
+		}
+		q.Start()
+		q.Append(payloadqueue.Payload{Id: "1", Data: "a"})
+		q.Append(payloadqueue.Payload{Id: "2", Data: "b"})
+		q.Append(payloadqueue.Payload{Id: "3", Data: "c"})
+		q.Append(payloadqueue.Payload{Id: "4", Data: "d"})
+		q.Append(payloadqueue.Payload{Id: "5", Data: "e"})
+		time.Sleep(200 * time.Millisecond)
+		q.Close()
+
+		runMutex.Lock()
+		if len(batches) != 2 || len(batches[1]) != 3 || batches[1][2] != "b" {
+			t.Errorf("Expected b to be retried in the second batch, got %v", batches)
+		}
+		runMutex.Unlock()
+		if pls := dl.Payloads(); len(pls) != 1 || pls[0].Id != "2" || pls[0].Result != 500 {
+			t.Errorf("Expected only payload 2 to be dead-lettered, got %+v", pls)
+		}
+	})
+
+	t.Run("Missing results are treated as failed", func(t *testing.T) {
+		dl := &payloadqueue.MemoryDeadLetter[interface{}]{}
+		q := &payloadqueue.Queue{
+			Tag:        "QueueWorkEach",
+			DeadLetter: dl,
+			WorkEach:   func(pls []interface{}) []int { return []int{0} },
+		}
+		q.Run([]payloadqueue.Payload{{Id: "1"}, {Id: "2"}})
+		if pls := dl.Payloads(); len(pls) != 1 || pls[0].Id != "2" || pls[0].Result != -1 {
+			t.Errorf("Expected payload 2 to fail with -1, got %+v", pls)
+		}
+	})
+}
+
+func TestQueueMaxBytes(t *testing.T) {
+	t.Run("Batch is flushed before it exceeds MaxBytes", func(t *testing.T) {
+		var runMutex sync.Mutex
+		var batches [][]interface{}
+
+		q := &payloadqueue.Queue{
+			MaxSize:  100,
+			MaxAge:   200,
+			MaxBytes: 10,
+			SizeFunc: func(pl interface{}) int { return len(pl.(string)) },
+			Tag:      "QueueMaxBytes",
+			Work: func(pls []interface{}) int {
+				runMutex.Lock()
+				batches = append(batches, pls)
+				runMutex.Unlock()
+				return 0
+			},
+		}
+		q.Start()
+		q.Append(payloadqueue.Payload{Id: "1", Data: "aaaa"})
+		q.Append(payloadqueue.Payload{Id: "2", Data: "bbbb"})
+		q.Append(payloadqueue.Payload{Id: "3", Data: "cccc"})   // 12 bytes: 1 and 2 are flushed first
+		q.Append(payloadqueue.Payload{Id: "4", Data: "dddddd"}) // exactly 10 bytes
+		time.Sleep(100 * time.Millisecond)
+
+		runMutex.Lock()
+		if len(batches) != 2 || len(batches[0]) != 2 || len(batches[1]) != 2 {
+			t.Errorf("Expected 2 batches of 2, got %v", batches)
+		}
+		runMutex.Unlock()
+		q.Close()
+	})
+
+	t.Run("Oversized payload is rejected", func(t *testing.T) {
+		q := &payloadqueue.Queue{
+			MaxSize:  100,
+			MaxAge:   200,
+			MaxBytes: 10,
+			Tag:      "QueueMaxBytes",
+			Work:     func(pls []interface{}) int { return 0 },
+		}
+		q.Start()
+		if err := q.Append(payloadqueue.Payload{Id: "1", Data: "a string longer than ten bytes"}); err == nil {
+			t.Errorf("Expected an error for the oversized payload")
+		}
+		if q.Size() != 0 {
+			t.Errorf("Expected q.Size() to be 0, got %d", q.Size())
+		}
+		q.Close()
+	})
+
+	t.Run("Oversized payload is sent alone", func(t *testing.T) {
+		var runMutex sync.Mutex
+		var batches [][]interface{}
+
+		q := &payloadqueue.Queue{
+			MaxSize:       100,
+			MaxAge:        200,
+			MaxBytes:      10,
+			SendOversized: true,
+			Tag:           "QueueMaxBytes",
+			Work: func(pls []interface{}) int {
+				runMutex.Lock()
+				batches = append(batches, pls)
+				runMutex.Unlock()
+				return 0
+			},
+		}
+		q.Start()
+		q.Append(payloadqueue.Payload{Id: "1", Data: "a"})
+		if err := q.Append(payloadqueue.Payload{Id: "2", Data: "a string longer than ten bytes"}); err != nil {
+			t.Errorf("Unexpected error: %s", err.Error())
+		}
+		time.Sleep(100 * time.Millisecond)
+
+		runMutex.Lock()
+		if len(batches) != 1 || len(batches[0]) != 1 {
+			t.Errorf("Expected the oversized payload alone, got %v", batches)
+		}
+		runMutex.Unlock()
+		if q.Size() != 1 {
+			t.Errorf("Expected q.Size() to be 1, got %d", q.Size())
+		}
+		q.Close()
+	})
+	t.Run("Event handlers can call back into the queue when the size fails", func(t *testing.T) {
+		q := &payloadqueue.Queue{
+			MaxSize:  100,
+			MaxAge:   200,
+			MaxBytes: 10,
+			Tag:      "QueueMaxBytes",
+			Work:     func(pls []interface{}) int { return 0 },
+		}
+		q.Events = func(payloadqueue.Event) { q.Size() }
+		q.Start()
+		done := make(chan struct{})
+		go func() {
+			// a channel cannot be encoded as JSON
+			q.Append(payloadqueue.Payload{Id: "1", Data: make(chan int)})
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("Expected Append to return")
+		}
+		q.Close()
+	})
+}
@@ -0,0 +1,185 @@
+package payloadqueue_test
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sam-ish/payloadqueue"
+)
+
+// These tests are meant to be run with -race. They hammer the public methods of both
+// queues from many goroutines and check that no payload is lost or run twice.
+
+func TestQueueConcurrency(t *testing.T) {
+	t.Run("Concurrent Append, Size and Run", func(t *testing.T) {
+		var worked int64
+		q := &payloadqueue.Queue{
+			MaxSize: 7,
+			MaxAge:  1,
+			Tag:     "QueueRace",
+			KeyFunc: func(pl interface{}) string { return strconv.Itoa(pl.(int) % 3) },
+			Work: func(pls []interface{}) int {
+				atomic.AddInt64(&worked, int64(len(pls)))
+				return 0
+			},
+		}
+		q.Start()
+
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := 0; i < 100; i++ {
+					q.Append(payloadqueue.Payload{Id: strconv.Itoa(g*100 + i), Data: i})
+					q.Size()
+					q.Partitions()
+				}
+			}(g)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				q.Run([]payloadqueue.Payload{{Id: "run" + strconv.Itoa(i), Data: i}})
+				q.Append(payloadqueue.Payload{})
+			}
+		}()
+		wg.Wait()
+		q.Close()
+
+		if total := atomic.LoadInt64(&worked) + int64(q.Size()); total != 820 {
+			t.Errorf("Expected 820 payloads worked or buffered, got %d", total)
+		}
+	})
+
+	t.Run("Append while closing", func(t *testing.T) {
+		var worked, refused int64
+		q := &payloadqueue.Queue{
+			MaxSize:              5,
+			MaxAge:               1,
+			MaxConcurrentBatches: 2,
+			Tag:                  "QueueRace",
+			Work: func(pls []interface{}) int {
+				atomic.AddInt64(&worked, int64(len(pls)))
+				return 0
+			},
+		}
+		q.Start()
+
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := 0; i < 100; i++ {
+					if err := q.Append(payloadqueue.Payload{Id: strconv.Itoa(g*100 + i)}); err != nil {
+						atomic.AddInt64(&refused, 1)
+					}
+				}
+			}(g)
+		}
+		time.Sleep(time.Millisecond)
+		q.Close()
+		wg.Wait()
+
+		if total := atomic.LoadInt64(&worked) + atomic.LoadInt64(&refused) + int64(q.Size()); total != 800 {
+			t.Errorf("Expected 800 payloads worked, refused or buffered, got %d", total)
+		}
+	})
+}
+
+func TestRateQueueConcurrency(t *testing.T) {
+	t.Run("Concurrent Append, Pause, Restart and Close", func(t *testing.T) {
+		var worked, refused int64
+		q := &payloadqueue.RateQueue{
+			MaxSize:           1000,
+			RequestsPerSecond: 1000,
+			Tag:               "RateQueueRace",
+			Work: func(pl interface{}) int {
+				atomic.AddInt64(&worked, 1)
+				return 0
+			},
+		}
+		q.Start()
+
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := 0; i < 50; i++ {
+					if err := q.Append(payloadqueue.Payload{Id: strconv.Itoa(g*50 + i)}); err != nil {
+						atomic.AddInt64(&refused, 1)
+					}
+					q.Size()
+				}
+			}(g)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				q.Pause()
+				q.RunNext()
+				q.Restart()
+				q.RunNext()
+			}
+		}()
+		wg.Wait()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := q.CloseContext(ctx); err != nil {
+			t.Errorf("Unexpected error: %s", err.Error())
+		}
+
+		if total := atomic.LoadInt64(&worked) + atomic.LoadInt64(&refused); total != 400 {
+			t.Errorf("Expected 400 payloads worked or refused, got %d", total)
+		}
+		if q.Size() != 0 {
+			t.Errorf("Expected q.Size() to be 0, got %d", q.Size())
+		}
+	})
+
+	t.Run("Append while closing", func(t *testing.T) {
+		var worked, refused int64
+		q := &payloadqueue.RateQueue{
+			MaxSize:           1000,
+			RequestsPerSecond: 1000,
+			Tag:               "RateQueueRace",
+			Work: func(pl interface{}) int {
+				atomic.AddInt64(&worked, 1)
+				return 0
+			},
+		}
+		q.Start()
+
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := 0; i < 50; i++ {
+					if err := q.Append(payloadqueue.Payload{Id: strconv.Itoa(g*50 + i)}); err != nil {
+						atomic.AddInt64(&refused, 1)
+					}
+				}
+			}(g)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.Close()
+		}()
+		q.Close()
+		wg.Wait()
+
+		if total := atomic.LoadInt64(&worked) + atomic.LoadInt64(&refused) + int64(q.Size()); total != 400 {
+			t.Errorf("Expected 400 payloads worked, refused or left, got %d", total)
+		}
+	})
+}
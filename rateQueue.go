@@ -1,165 +1,636 @@
 package payloadqueue
 
 import (
+	"context"
 	"errors"
-	"fmt"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
-
-	"github.com/google/uuid"
 )
 
-// RateQueue to hold the main application queuing mechanism.
-type RateQueue struct {
+// TypedRateQueue to hold the main application queuing mechanism. Payloads of type T
+// are handed to Work one at a time at a steady rate.
+type TypedRateQueue[T any] struct {
 	Tag               string
-	MaxSize           int // Default is 100,000
-	RequestsPerSecond int
-	Work              rateWorkHandler
+	MaxSize           int                // Default is 100,000
+	RequestsPerSecond int                // shortcut for Rate per second
+	Rate              float64            // requests per Per, e.g. 0.5 or 2500. Used instead of RequestsPerSecond when supplied
+	Per               time.Duration      // Default is 1 second
+	Burst             int                // requests that can run back to back after idling. Default is 1
+	MaxInFlight       int                // Work calls running at once. Default is 1
+	Adaptive          *Adaptive          // adjusts the rate to the Work results when supplied
+	Limiter           Limiter            // used instead of Rate when supplied, e.g. a RedisLimiter shared by replicas
+	Lanes             []Lane             // in order of priority. Payloads with no Lane go to the last one
+	Scheduling        Scheduling         // how the Lanes are served. Default is StrictPriority
+	MaxWait           time.Duration      // a payload waiting for longer is served first whatever its lane. 0 is off
+	KeyFunc           func(T) string     // e.g. the tenant. Keys are served fairly by deficit round-robin
+	KeyMaxSize        int                // payloads queued per key. 0 is unlimited
+	KeyRate           float64            // requests per Per for each key on top of Rate. 0 is no cap
+	KeyRates          map[string]float64 // per-key overrides of KeyRate
+	KeyIdle           time.Duration      // buckets of the keys idle for longer are evicted. Default is 5 minutes
+	Quantum           int                // cost a key can spend per round-robin turn. Default is 1
+	CostFunc          func(T) int        // cost of an item in the round-robin. Default is 1
+	Work              rateWorkHandler[T]
+	WorkContext       rateWorkContextHandler[T] // used instead of Work when supplied. The context is cancelled by CloseContext.
+	WorkResult        rateWorkResultHandler[T]  // used instead of WorkContext when supplied. The Result can hold back the queue.
 	EventFeed         eventFeed
-	DiscardOnClose    bool
+	Events            EventHandler       // receives the typed events. EventFeed receives them as strings
+	Metrics           Metrics            // receives the measurements of the queue when supplied
+	Tracer            Tracer             // starts a span per Work call, linked to the span given to AppendContext
+	Clock             Clock              // the source of time. Default is the system clock
+	Retry             RetryPolicy        // failed payloads are appended again when the policy allows it
+	DeadLetter        TypedDeadLetter[T] // receives the payloads that are not retried
+	WAL               *TypedWAL[T]       // payloads are logged to disk and replayed by Start when supplied
+	DiscardOnClose    bool               // pending payloads are not flushed by Close. They stay in the WAL, if any
+	Breaker           *CircuitBreaker    // the payloads are held in the queue while it is open
 	payloadMutex      sync.Mutex
-	payloadQueue      []Payload
-	payloadChan       chan Payload
+	lanes             []*lane[T]
+	keyLimits         map[string]*keyLimit // a token bucket per key with a rate limit
+	keysEvicted       time.Time            // when the idle keys were last evicted
+	schedule          schedule[T]          // payloads held until their NotBefore
 	quitChan          chan bool
-	delay             time.Duration
-	active            bool
+	closeOnce         sync.Once
+	ctx               context.Context // passed to WorkContext
+	cancel            context.CancelFunc
+	activeWork        sync.WaitGroup
+	bucket            *tokenBucket
+	notify            chan struct{} // wakes the dispatcher when payloads are appended or restarted
+	inFlight          chan struct{} // a slot per running Work call
+	running           int64         // Work calls running, for Metrics.InFlight
+	adaptMutex        sync.Mutex
+	active            bool      // guarded by payloadMutex like closed and stopped
+	closed            bool      // set by CloseContext. Append is refused once closed
+	stopped           bool      // set once the flush on close is done. RunNext does nothing once stopped
+	resumeAt          time.Time // dispatching is held back until then by a Result RetryAfter
 }
 
+// RateQueue is the interface{}-based TypedRateQueue. Work receives each item as interface{}.
+type RateQueue = TypedRateQueue[interface{}]
+
 // Start to open the queue to receive payload to batch
-func (q *RateQueue) Start() error {
-	if q.RequestsPerSecond < 1 {
+func (q *TypedRateQueue[T]) Start() error {
+	return q.StartContext(context.Background())
+}
+
+// StartContext to open the queue to receive payload to batch. The queue is closed
+// when ctx is cancelled.
+func (q *TypedRateQueue[T]) StartContext(ctx context.Context) error {
+	if q.Rate == 0 {
+		q.Rate = float64(q.RequestsPerSecond)
+	}
+	if q.Per == 0 {
+		q.Per = time.Second
+	}
+	if q.Rate < 0 || q.Per <= 0 || q.Rate == 0 && q.Limiter == nil && q.KeyRate <= 0 && len(q.KeyRates) == 0 {
+		// Rate can only be left out for a Limiter or when the keys have their own rate limits
 		return errors.New("rateQueues cannot have zero requests/second")
 	}
-	if q.Work == nil {
+	if q.Limiter != nil && q.Adaptive != nil {
+		return errors.New("adaptive cannot be used with a Limiter")
+	}
+	if q.Work == nil && q.WorkContext == nil && q.WorkResult == nil {
 		return errors.New("the Work function is not supplied")
 	}
-	q.delay = time.Duration(1000/q.RequestsPerSecond) * time.Millisecond
+	if err := validateLanes(q.Lanes); err != nil {
+		return err
+	}
+	if q.Tag == "" {
+		q.Tag = defaultTag(12)
+		q.event(Event{Kind: EventDefault, Message: "Tag: Random value assigned is: " + q.Tag})
+	}
+	rate := q.Rate
+	if q.Adaptive != nil {
+		if err := q.Adaptive.init(q.Rate); err != nil {
+			return err
+		}
+		rate = q.Adaptive.clamp(rate)
+	}
+	if rate > 0 && q.Limiter == nil {
+		q.bucket = newTokenBucket(rate/q.Per.Seconds(), q.Burst, q.clock().Now())
+		q.metrics().Rate(q.Tag, rate)
+	}
 	if q.MaxSize == 0 {
 		q.MaxSize = 100000
-		q.event("MaxSize: Default value of 100 was used")
+		q.event(Event{Kind: EventDefault, Message: "MaxSize: Default value of 100 was used"})
 	}
-	if q.RequestsPerSecond > 1000 {
-		q.RequestsPerSecond = 1000
-		q.event("RequestsPerSecond: Max value of 1000 was used")
+	var replay []TypedPayload[T]
+	if q.WAL != nil {
+		var err error
+		if replay, err = q.WAL.Open(); err != nil {
+			return err
+		}
 	}
-	if q.Tag == "" {
-		q.Tag = defaultTag(12)
-		q.event("Tag: Random value assigned is: " + q.Tag)
+	q.ctx, q.cancel = context.WithCancel(context.Background())
+	q.quitChan = make(chan bool)
+	q.schedule.clock = q.Clock
+	if q.Breaker != nil && q.Breaker.Clock == nil {
+		q.Breaker.Clock = q.Clock
 	}
 
-	go func() {
-		// Check for the max age
-		for {
-			time.Sleep(q.delay)
-			q.RunNext()
-		}
-	}()
+	if q.MaxInFlight == 0 {
+		q.MaxInFlight = 1
+	}
+	q.notify = make(chan struct{}, 1)
+	q.inFlight = make(chan struct{}, q.MaxInFlight)
 
 	go func() {
 		for {
+			// Wait for a payload to dispatch and a free MaxInFlight slot, then for a
+			// token to run it. A released slot wakes the dispatcher up, which is also
+			// how a half-open Breaker is woken once its trial call is done.
+			var timer Timer
+			var tick <-chan time.Time
+			ready, wait := q.breakerReady()
+			if held := q.holdback(); held > wait {
+				wait = held
+			}
+			if wait > 0 && q.dispatchable() {
+				timer = q.clock().NewTimer(wait)
+				tick = timer.C()
+			} else if ready && q.dispatchable() {
+				select {
+				case q.inFlight <- struct{}{}:
+					wait := q.take()
+					if wait == 0 {
+						go func() {
+							defer q.release()
+							q.runNext()
+						}()
+						continue
+					}
+					<-q.inFlight
+					timer = q.clock().NewTimer(wait)
+					tick = timer.C()
+				default:
+				}
+			}
 			select {
-			case p := <-q.payloadChan:
-				// Payload has been added for queuing
-				q.Append(p)
+			case <-tick:
+			case <-q.notify:
+				if timer != nil {
+					timer.Stop()
+				}
+
+			case <-ctx.Done():
+				// The parent context is done.
+				q.Close()
+				return
 
 			case <-q.quitChan:
 				// We have been asked to stop.
-				q.Close()
 				return
 			}
 		}
 	}()
-	q.event("RateQueue: Started")
+	q.event(Event{Kind: EventStarted, Message: "RateQueue: Started"})
+	q.payloadMutex.Lock()
 	q.active = true
+	q.payloadMutex.Unlock()
+	q.wake()
+	if len(replay) > 0 {
+		q.event(Event{Kind: EventWAL, Size: len(replay), Message: "WAL: Replaying " + strconv.Itoa(len(replay)) + " payload(s)"})
+		for _, p := range replay {
+			q.Append(p)
+		}
+	}
 	return nil
 }
 
-func (q RateQueue) NewPayload(pl interface{}) Payload {
-	if pl == nil {
-		return Payload{
-			Id:   "",
-			Data: "",
+// NewPayload to wrap the data into a payload for this queue
+func (q *TypedRateQueue[T]) NewPayload(pl T) TypedPayload[T] {
+	return NewPayload(pl)
+}
+
+// take to consume a token from the Limiter or the Rate bucket, if any. When none is
+// available, the time until the next one is returned.
+func (q *TypedRateQueue[T]) take() time.Duration {
+	if q.Limiter != nil {
+		wait, err := q.Limiter.Take(q.ctx)
+		if err != nil {
+			q.event(Event{Kind: EventLimiter, Level: LevelError, Err: err, Duration: limiterBackoff, Message: "Limiter: " + err.Error() + ". Retrying in " + limiterBackoff.String()})
+			return limiterBackoff
+		}
+		return wait
+	}
+	if q.bucket == nil {
+		return 0
+	}
+	return q.bucket.take(q.clock().Now())
+}
+
+// RunNext to push the next payload for processing once a MaxInFlight slot is free
+func (q *TypedRateQueue[T]) RunNext() {
+	if q.inFlight != nil {
+		q.inFlight <- struct{}{}
+		defer q.release()
+	}
+	q.runNext()
+}
+
+// release to free a MaxInFlight slot and wake the dispatcher
+func (q *TypedRateQueue[T]) release() {
+	<-q.inFlight
+	q.wake()
+}
+
+// InFlight to return the number of Work calls running
+func (q *TypedRateQueue[T]) InFlight() int {
+	return len(q.inFlight)
+}
+
+// runNext to pop the next payload and call Work with it
+func (q *TypedRateQueue[T]) runNext() {
+	q.payloadMutex.Lock()
+	if q.queued() < 1 || !q.active || q.stopped {
+		q.payloadMutex.Unlock()
+		return
+	}
+	allowed, changed := q.allow()
+	if !allowed {
+		q.payloadMutex.Unlock()
+		q.breakerChanged(changed)
+		return
+	}
+	pl, ok := q.pop()
+	if !ok {
+		// every queued key is held back by its rate limit
+		if q.Breaker != nil {
+			q.Breaker.release()
 		}
+		q.payloadMutex.Unlock()
+		q.breakerChanged(changed)
+		return
+	}
+	q.activeWork.Add(1)
+	depth := q.queued()
+	q.payloadMutex.Unlock()
+	q.breakerChanged(changed)
+	q.metrics().Depth(q.Tag, depth)
+	defer q.activeWork.Done()
+	pl.Attempts++
+	pl.LastAttempt = q.clock().Now()
+	ctx, end := q.trace([]TypedPayload[T]{pl})
+	q.metrics().InFlight(q.Tag, int(atomic.AddInt64(&q.running, 1)))
+	res := q.work(ctx, pl.Data)
+	result := res.Code
+	end([]int{result})
+	q.metrics().WorkDuration(q.Tag, q.clock().Now().Sub(pl.LastAttempt))
+	q.metrics().InFlight(q.Tag, int(atomic.AddInt64(&q.running, -1)))
+	q.metrics().Results(q.Tag, result, 1)
+	q.event(Event{Kind: EventPushed, Level: LevelDebug, Ids: []string{pl.Id}, Size: 1, Result: result, Duration: q.clock().Now().Sub(pl.LastAttempt), Message: "Pushed [" + pl.Id + "] @ " + q.clock().Now().UTC().String() + ". Result: " + strconv.Itoa(result)})
+	q.adapt(result)
+	q.record(result == 0)
+	if res.RetryAfter > 0 {
+		pl.Result = result
+		q.retryAfter(pl, res.RetryAfter)
+		return
+	}
+	if result != 0 {
+		pl.Result = result
+		q.retry(pl, result)
+	} else {
+		q.ack(pl)
+	}
+}
+
+// retry to schedule the failed payload again once the RetryPolicy backoff has elapsed,
+// so a retry pending at Close is kept like the scheduled payloads. Payloads that are
+// not retried are sent to the DeadLetter.
+func (q *TypedRateQueue[T]) retry(p TypedPayload[T], result int) {
+	var delay time.Duration
+	ok := false
+	if q.Retry != nil {
+		delay, ok = q.Retry.Retry(p.Attempts, result)
+	}
+	if !ok {
+		q.fail(p, result)
+		return
 	}
-	u := uuid.New()
-	return Payload{
-		Id:   u.String(),
-		Data: pl,
+	q.event(Event{Kind: EventRetry, Level: LevelWarn, Ids: []string{p.Id}, Result: result, Duration: delay, Message: "Payload Retry [id]: " + p.Id + ". Attempt " + strconv.Itoa(p.Attempts+1) + " in " + delay.String()})
+	switch err := q.AppendAfter(p, delay); err {
+	case nil:
+	case errClosed:
+		q.unschedule([]TypedPayload[T]{p})
+	default:
+		q.deadLetter(p)
+		q.ack(p)
 	}
 }
 
-// Run to push the Batch for processing
-func (q *RateQueue) RunNext() {
-	if len(q.payloadQueue) < 1 || !q.active {
+// fail to give up on the payload: it is sent to the DeadLetter and removed from the WAL
+func (q *TypedRateQueue[T]) fail(p TypedPayload[T], result int) {
+	q.event(Event{Kind: EventFailed, Level: LevelError, Ids: []string{p.Id}, Result: result, Message: "Payload Failed [id]: " + p.Id + ". Result Code: " + strconv.Itoa(result) + " after " + strconv.Itoa(p.Attempts) + " attempt(s)"})
+	q.deadLetter(p)
+	q.ack(p)
+}
+
+// deadLetter to hand the failed payload to the DeadLetter, if any
+func (q *TypedRateQueue[T]) deadLetter(p TypedPayload[T]) {
+	if q.DeadLetter == nil {
+		return
+	}
+	if err := q.DeadLetter.Add(p); err != nil {
+		q.event(Event{Kind: EventDeadLetter, Level: LevelError, Ids: []string{p.Id}, Err: err, Message: "Dead Letter [id]: " + p.Id + " failed. " + err.Error()})
 		return
 	}
-	var pl Payload
+	q.event(Event{Kind: EventDeadLetter, Level: LevelWarn, Ids: []string{p.Id}, Message: "Dead Letter [id]: " + p.Id})
+}
 
+// ack to remove the completed payload from the WAL, if any
+func (q *TypedRateQueue[T]) ack(p TypedPayload[T]) {
+	if q.WAL == nil {
+		return
+	}
+	if err := q.WAL.Ack(p.Id); err != nil {
+		q.event(Event{Kind: EventWAL, Level: LevelError, Ids: []string{p.Id}, Err: err, Message: "WAL: Ack failed. " + err.Error()})
+	}
+}
+
+// Redrive to drain the dead letter and append its payloads back into the queue.
+// Payloads that do not fit in the queue stay in the dead letter.
+func (q *TypedRateQueue[T]) Redrive(d TypedDeadLetter[T]) (int, error) {
+	return redrive[T](d, q)
+}
+
+// work to call the supplied Work handler with the payload data
+func (q *TypedRateQueue[T]) work(ctx context.Context, data T) Result {
+	switch {
+	case q.WorkResult != nil:
+		return q.WorkResult(ctx, data)
+	case q.WorkContext != nil:
+		return Result{Code: q.WorkContext(ctx, data)}
+	}
+	return Result{Code: q.Work(data)}
+}
+
+// retryAfter to hold the dispatching back for the RetryAfter duration of the payload.
+// The Retry policy, if any, caps its attempts like for a failure. Once the queue is
+// closing, the RetryAfter is not waited for and the payload is kept in the WAL or
+// handed to the DeadLetter instead.
+func (q *TypedRateQueue[T]) retryAfter(p TypedPayload[T], d time.Duration) {
+	if q.Retry != nil {
+		if _, ok := q.Retry.Retry(p.Attempts, p.Result); !ok {
+			q.fail(p, p.Result)
+			return
+		}
+	}
+	if !q.holdOff(p, d) {
+		q.event(Event{Kind: EventHeldBack, Level: LevelWarn, Ids: []string{p.Id}, Result: p.Result, Duration: d, Message: "Rate Queue: Retry-After of " + d.String() + " by [" + p.Id + "] is not waited for on Close"})
+		q.unschedule([]TypedPayload[T]{p})
+	}
+}
+
+// holdOff to put the payload back at the head of its lane and hold the dispatching
+// back for the RetryAfter duration. It returns false once the queue is closed.
+func (q *TypedRateQueue[T]) holdOff(p TypedPayload[T], d time.Duration) bool {
 	q.payloadMutex.Lock()
-	pl, q.payloadQueue = q.payloadQueue[0], q.payloadQueue[1:]
+	if q.closed {
+		q.payloadMutex.Unlock()
+		return false
+	}
+	if l, err := q.lane(p); err == nil {
+		l.push(q.key(p.Data), p, true)
+	}
+	if resume := q.clock().Now().Add(d); resume.After(q.resumeAt) {
+		q.resumeAt = resume
+	}
 	q.payloadMutex.Unlock()
-	go q.event("Pushed [" + pl.Id + "] @ " + time.Now().UTC().String() + ". Result: " + strconv.Itoa(q.Work(pl.Data)))
+	q.event(Event{Kind: EventHeldBack, Level: LevelWarn, Ids: []string{p.Id}, Result: p.Result, Duration: d, Message: "Rate Queue: Held back for " + d.String() + " by [" + p.Id + "]. Retry-After"})
+	return true
+}
+
+// holdback to return how long the dispatching is still held back by a RetryAfter or,
+// when every queued key is capped, by the key rate limits
+func (q *TypedRateQueue[T]) holdback() time.Duration {
+	q.payloadMutex.Lock()
+	defer q.payloadMutex.Unlock()
+	now := q.clock().Now()
+	wait := q.resumeAt.Sub(now)
+	if keyWait := q.keyWait(now); keyWait > wait {
+		wait = keyWait
+	}
+	return wait
 }
 
 // Append to add a Payload to the queue.
-func (q *RateQueue) Append(p Payload) error {
+func (q *TypedRateQueue[T]) Append(p TypedPayload[T]) error {
+	return q.add(p, false)
+}
 
+// add to append the payload. A due payload comes from the schedule and was already
+// counted as enqueued by AppendAt.
+func (q *TypedRateQueue[T]) add(p TypedPayload[T], due bool) error {
+	q.payloadMutex.Lock()
+	if q.closed {
+		q.payloadMutex.Unlock()
+		q.metrics().Rejected(q.Tag, RejectedClosed)
+		q.event(Event{Kind: EventAppendFailed, Level: LevelWarn, Ids: []string{p.Id}, Err: errClosed, Message: "Payload " + p.Id + " failed. " + errClosed.Error()})
+		return errClosed
+	}
 	// Check the conditions for firing the Work()
 	// 1. Queue is full
-	if len(q.payloadQueue) >= q.MaxSize {
-		q.event("Payload " + p.Id + " failed. RateQueue is full")
+	if q.queued()+q.schedule.len() >= q.MaxSize {
+		q.payloadMutex.Unlock()
+		q.metrics().Rejected(q.Tag, RejectedFull)
+		q.event(Event{Kind: EventAppendFailed, Level: LevelWarn, Ids: []string{p.Id}, Message: "Payload " + p.Id + " failed. RateQueue is full"})
 		return errors.New("Payload " + p.Id + " failed. RateQueue is full. Try again later")
 	}
 	// Add to the queue
 	if p.Id != "" {
-		q.payloadMutex.Lock()
-		q.payloadQueue = append(q.payloadQueue, p)
+		reason := RejectedLane
+		l, err := q.lane(p)
+		key := q.key(p.Data)
+		if err == nil {
+			reason = RejectedKeyFull
+			err = q.checkKey(p, key)
+		}
+		if err != nil {
+			q.payloadMutex.Unlock()
+			q.metrics().Rejected(q.Tag, reason)
+			q.event(Event{Kind: EventAppendFailed, Level: LevelWarn, Ids: []string{p.Id}, Err: err, Message: err.Error()})
+			return err
+		}
+		if p.Queued.IsZero() {
+			p.Queued = q.clock().Now()
+		}
+		if q.WAL != nil {
+			if err := q.WAL.Append(p); err != nil {
+				q.payloadMutex.Unlock()
+				q.metrics().Rejected(q.Tag, RejectedWAL)
+				q.event(Event{Kind: EventAppendFailed, Level: LevelWarn, Ids: []string{p.Id}, Err: err, Message: "Payload " + p.Id + " failed. " + err.Error()})
+				return err
+			}
+		}
+		if p.NotBefore.After(q.clock().Now()) {
+			scheduled := q.schedule.push(p, q.due)
+			q.payloadMutex.Unlock()
+			if !scheduled {
+				q.metrics().Rejected(q.Tag, RejectedClosed)
+				return errClosed
+			}
+			q.metrics().Enqueued(q.Tag)
+			q.event(Event{Kind: EventScheduled, Level: LevelDebug, Ids: []string{p.Id}, Message: "Payload Scheduled [id]: " + p.Id + " @ " + p.NotBefore.String()})
+			return nil
+		}
+		l.push(key, p, false)
+		evicted := q.evictKeys(q.clock().Now())
+		depth := q.queued()
 		q.payloadMutex.Unlock()
-		q.event("Payload Queued [id]: " + p.Id)
+		if !due {
+			q.metrics().Enqueued(q.Tag)
+		}
+		q.metrics().Depth(q.Tag, depth)
+		q.wake()
+		q.event(Event{Kind: EventQueued, Level: LevelDebug, Ids: []string{p.Id}, Message: "Payload Queued [id]: " + p.Id})
+		for _, e := range evicted {
+			q.event(e)
+		}
+		return nil
 	}
+	q.payloadMutex.Unlock()
 	return nil
 }
 
 // Size to return the number of jobs in the queue.
-func (q *RateQueue) Size() int {
-	return len(q.payloadQueue)
+func (q *TypedRateQueue[T]) Size() int {
+	q.payloadMutex.Lock()
+	defer q.payloadMutex.Unlock()
+	return q.queued()
 }
 
-// Pause to return the number of jobs in the queue.
-func (q *RateQueue) Pause() {
+// Pause to stop dispatching the payloads until Restart is called.
+func (q *TypedRateQueue[T]) Pause() {
+	q.payloadMutex.Lock()
 	q.active = false
+	q.payloadMutex.Unlock()
 }
 
-// Restart to return the number of jobs in the queue.
-func (q *RateQueue) Restart() {
+// Restart to resume dispatching the payloads after a Pause.
+func (q *TypedRateQueue[T]) Restart() {
+	q.payloadMutex.Lock()
 	q.active = true
+	q.payloadMutex.Unlock()
+	q.wake()
 }
 
-// Close to close the channels and wait for Work funcs to quit the execution.
-func (q *RateQueue) Close() {
-	q.event("Rate Queue: Stopping...")
-	if q.payloadChan != nil {
-		close(q.payloadChan)
+// wake to tell the dispatcher that there may be a payload to run
+func (q *TypedRateQueue[T]) wake() {
+	select {
+	case q.notify <- struct{}{}:
+	default:
 	}
-	if q.quitChan != nil {
-		close(q.quitChan)
+}
+
+// dispatchable to check if the dispatcher has a payload to run
+func (q *TypedRateQueue[T]) dispatchable() bool {
+	q.payloadMutex.Lock()
+	defer q.payloadMutex.Unlock()
+	return q.queued() > 0 && q.active && !q.stopped
+}
+
+// pending to return the number of payloads left to flush on close
+func (q *TypedRateQueue[T]) pending() int {
+	q.payloadMutex.Lock()
+	defer q.payloadMutex.Unlock()
+	if !q.active {
+		return 0
 	}
-	if !q.DiscardOnClose {
-		// Flush all active routines to be completed
-		q.delay = 100000
-		fmt.Println("Pending Payloads in Queue: " + strconv.Itoa(len(q.payloadQueue)))
-		for len(q.payloadQueue) > 0 {
-			q.RunNext()
+	return q.queued()
+}
+
+// Close to close the channels and wait for Work funcs to quit the execution.
+func (q *TypedRateQueue[T]) Close() {
+	q.CloseContext(context.Background())
+}
+
+// CloseContext to close the channels and flush the pending payloads unless DiscardOnClose
+// is set. If ctx is done before the flush has completed, the remaining payloads are left
+// in the queue, the context passed to WorkContext is cancelled and ctx.Err() is returned.
+// A Result RetryAfter is not waited for: the payload is kept in the WAL or handed to the
+// DeadLetter instead.
+func (q *TypedRateQueue[T]) CloseContext(ctx context.Context) error {
+	q.event(Event{Kind: EventStopping, Message: "Rate Queue: Stopping..."})
+	q.closeOnce.Do(func() {
+		q.payloadMutex.Lock()
+		q.closed = true
+		// a RetryAfter is not waited for by the flush on close
+		q.resumeAt = time.Time{}
+		q.payloadMutex.Unlock()
+		if q.quitChan != nil {
+			close(q.quitChan)
 		}
+		q.closeSchedule()
+	})
+	done := make(chan bool)
+	go func() {
+		if !q.DiscardOnClose {
+			// Flush all active routines to be completed
+			q.event(Event{Kind: EventPending, Size: q.Size(), Message: "Pending Payloads in Queue: " + strconv.Itoa(q.Size())})
+			for q.pending() > 0 && ctx.Err() == nil {
+				if ready, _ := q.breakerReady(); !ready {
+					q.event(Event{Kind: EventPending, Level: LevelWarn, Size: q.Size(), Err: errBreakerOpen, Message: "Rate Queue: " + strconv.Itoa(q.Size()) + " payload(s) left in the queue. " + errBreakerOpen.Error()})
+					break
+				}
+				// the flush keeps to the Rate and the Limiter shared with other replicas
+				wait := q.holdback()
+				if wait == 0 {
+					wait = q.take()
+				}
+				if wait > 0 {
+					timer := q.clock().NewTimer(wait)
+					select {
+					case <-timer.C():
+					case <-ctx.Done():
+					}
+					timer.Stop()
+					continue
+				}
+				q.RunNext()
+			}
+		}
+		q.payloadMutex.Lock()
+		q.stopped = true
+		q.payloadMutex.Unlock()
+		q.activeWork.Wait()
+		close(done)
+	}()
+	var err error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
 	}
+	if q.cancel != nil {
+		q.cancel()
+	}
+	q.payloadMutex.Lock()
 	q.active = false
-	q.event("Rate Queue: All Work completed")
+	q.payloadMutex.Unlock()
+	if q.WAL != nil {
+		q.WAL.Close()
+	}
+	if err != nil {
+		q.event(Event{Kind: EventStopped, Level: LevelWarn, Err: err, Message: "Rate Queue: Shutdown deadline passed. Active Work cancelled"})
+		return err
+	}
+	q.event(Event{Kind: EventStopped, Message: "Rate Queue: All Work completed"})
+	return nil
 }
 
-// event to write events into the RateQueue's feed
-func (q *RateQueue) event(s string) {
+// event to write events into the RateQueue's feeds
+func (q *TypedRateQueue[T]) event(e Event) {
+	if q.Events == nil && q.EventFeed == nil {
+		return
+	}
+	e.Tag = q.Tag
+	if e.Time.IsZero() {
+		e.Time = q.clock().Now()
+	}
+	if q.Events != nil {
+		q.Events(e)
+	}
 	if q.EventFeed != nil {
-		q.EventFeed("[" + q.Tag + "] " + s)
+		q.EventFeed(e.String())
 	}
 }
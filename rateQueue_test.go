@@ -1,7 +1,11 @@
 package payloadqueue_test
 
 import (
+	"context"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -100,9 +104,11 @@ func TestRateQRunNext(t *testing.T) {
 		// Delay is important to be sure that the Work() goroutine has been called before the assertion
 		time.Sleep(2400 * time.Millisecond)
 		// Ensure that Run was triggered and the queue was reset
+		runMutex.Lock()
 		if runtimes != 2 {
 			t.Errorf("Expected runtimes to be 2, got %d", runtimes)
 		}
+		runMutex.Unlock()
 		if q.Size() != 2 {
 			t.Errorf("Expected q.Size() to be 2, got %d", q.Size())
 		}
@@ -217,15 +223,383 @@ func TestRateQPauseAndRestart(t *testing.T) {
 		q.Start()
 		q.Pause()
 		time.Sleep(2 * time.Second)
+		runMutex.Lock()
 		if runtimes > 0 {
 			t.Errorf("Pause Failed: Expected runtimes to be 0, got %d", runtimes)
 		}
+		runMutex.Unlock()
 		q.Restart()
 		time.Sleep(2 * time.Second)
 
+		runMutex.Lock()
 		if runtimes <= 3 {
 			t.Errorf("Expected runtimes to be > 2, got %d", runtimes)
 		}
+		runMutex.Unlock()
 		q.Close()
 	})
 }
+
+func TestTypedRateQueue(t *testing.T) {
+	type job struct {
+		Name string
+	}
+
+	t.Run("Work receives typed item", func(t *testing.T) {
+		var runMutex sync.Mutex
+		names := make([]string, 0)
+
+		q := &payloadqueue.TypedRateQueue[job]{
+			MaxSize:           5,
+			RequestsPerSecond: 10,
+			Tag:               "TypedRateQueue",
+			Work: func(j job) int {
+				runMutex.Lock()
+				names = append(names, j.Name)
+				runMutex.Unlock()
+				return 0
+			},
+		}
+		q.Start()
+		q.Append(q.NewPayload(job{Name: "Alpha"}))
+
+		time.Sleep(500 * time.Millisecond)
+		runMutex.Lock()
+		if len(names) != 1 || names[0] != "Alpha" {
+			t.Errorf("Expected [Alpha], got %v", names)
+		}
+		runMutex.Unlock()
+		q.Close()
+	})
+}
+
+func TestRateQContext(t *testing.T) {
+	t.Run("CloseContext cancels active WorkContext", func(t *testing.T) {
+		q := &payloadqueue.RateQueue{
+			MaxSize:           10,
+			RequestsPerSecond: 1,
+			Tag:               "RateQueueCtx",
+			WorkContext: func(ctx context.Context, pl interface{}) int {
+				<-ctx.Done()
+				return 1
+			},
+		}
+		q.Start()
+		q.Append(payloadqueue.Payload{Id: "1"})
+		q.Append(payloadqueue.Payload{Id: "2"})
+		q.Append(payloadqueue.Payload{Id: "3"})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		defer cancel()
+		if err := q.CloseContext(ctx); err != context.DeadlineExceeded {
+			t.Errorf("Expected DeadlineExceeded, got %v", err)
+		}
+	})
+
+	t.Run("StartContext closes on cancel", func(t *testing.T) {
+		var runMutex sync.Mutex
+		runtimes := 0
+		ctx, cancel := context.WithCancel(context.Background())
+		q := &payloadqueue.RateQueue{
+			MaxSize:           10,
+			RequestsPerSecond: 100,
+			Tag:               "RateQueueCtx",
+			Work: func(pl interface{}) int {
+				runMutex.Lock()
+				runtimes += 1
+				runMutex.Unlock()
+				return 0
+			},
+		}
+		q.StartContext(ctx)
+		q.Append(payloadqueue.Payload{Id: "1"})
+		q.Append(payloadqueue.Payload{Id: "2"})
+		cancel()
+		time.Sleep(200 * time.Millisecond)
+		// Close flushes the pending payloads
+		runMutex.Lock()
+		if runtimes != 2 {
+			t.Errorf("Expected runtimes to be 2, got %d", runtimes)
+		}
+		runMutex.Unlock()
+	})
+}
+
+func TestRateQRetry(t *testing.T) {
+	t.Run("Failed payload is retried up to MaxAttempts", func(t *testing.T) {
+		var runMutex sync.Mutex
+		runtimes := 0
+
+		q := &payloadqueue.RateQueue{
+			MaxSize:           5,
+			RequestsPerSecond: 20,
+			Tag:               "RateQueueRetry",
+			Retry: payloadqueue.ExponentialBackoff{
+				MaxAttempts:  3,
+				InitialDelay: 50 * time.Millisecond,
+			},
+			Work: func(pl interface{}) int {
+				runMutex.Lock()
+				runtimes += 1
+				runMutex.Unlock()
+				return 1
+			},
+		}
+		q.Start()
+		q.Append(payloadqueue.Payload{Id: "1"})
+
+		time.Sleep(time.Second)
+		runMutex.Lock()
+		if runtimes != 3 {
+			t.Errorf("Expected runtimes to be 3, got %d", runtimes)
+		}
+		runMutex.Unlock()
+		if q.Size() != 0 {
+			t.Errorf("Expected q.Size() to be 0, got %d", q.Size())
+		}
+		q.Close()
+	})
+}
+
+func TestRateQTokenBucket(t *testing.T) {
+	// counter records when each Work call ran
+	type counter struct {
+		mutex sync.Mutex
+		runs  []time.Time
+	}
+	work := func(c *counter) func(interface{}) int {
+		return func(pl interface{}) int {
+			c.mutex.Lock()
+			c.runs = append(c.runs, time.Now())
+			c.mutex.Unlock()
+			return 0
+		}
+	}
+
+	t.Run("Rate per duration", func(t *testing.T) {
+		c := &counter{}
+		q := &payloadqueue.RateQueue{
+			Rate: 5,
+			Per:  100 * time.Millisecond,
+			Tag:  "RateQueueBucket",
+			Work: work(c),
+		}
+		q.Start()
+		for i := 0; i < 10; i++ {
+			q.Append(payloadqueue.Payload{Id: strconv.Itoa(i)})
+		}
+		time.Sleep(110 * time.Millisecond)
+		c.mutex.Lock()
+		if len(c.runs) < 4 || len(c.runs) > 6 {
+			t.Errorf("Expected about 5 runs in 100ms, got %d", len(c.runs))
+		}
+		c.mutex.Unlock()
+		q.Close()
+	})
+
+	t.Run("Burst after idling", func(t *testing.T) {
+		c := &counter{}
+		q := &payloadqueue.RateQueue{
+			Rate:  10,
+			Burst: 5,
+			Tag:   "RateQueueBucket",
+			Work:  work(c),
+		}
+		q.Start()
+		time.Sleep(600 * time.Millisecond)
+		start := time.Now()
+		for i := 0; i < 6; i++ {
+			q.Append(payloadqueue.Payload{Id: strconv.Itoa(i)})
+		}
+		time.Sleep(50 * time.Millisecond)
+		c.mutex.Lock()
+		if len(c.runs) != 5 || c.runs[4].Sub(start) > 50*time.Millisecond {
+			t.Errorf("Expected a burst of 5 runs, got %d", len(c.runs))
+		}
+		c.mutex.Unlock()
+		q.Close()
+	})
+
+	t.Run("Rates above 1000/s are not capped", func(t *testing.T) {
+		c := &counter{}
+		q := &payloadqueue.RateQueue{
+			MaxSize:           1000,
+			RequestsPerSecond: 4000,
+			Tag:               "RateQueueBucket",
+			Work:              work(c),
+		}
+		q.Start()
+		for i := 0; i < 400; i++ {
+			q.Append(payloadqueue.Payload{Id: strconv.Itoa(i)})
+		}
+		time.Sleep(200 * time.Millisecond)
+		c.mutex.Lock()
+		if len(c.runs) < 300 {
+			t.Errorf("Expected more than 300 runs in 200ms, got %d", len(c.runs))
+		}
+		c.mutex.Unlock()
+		q.Close()
+	})
+
+	t.Run("Fractional and zero rates", func(t *testing.T) {
+		q := &payloadqueue.RateQueue{
+			Rate: 0.5,
+			Tag:  "RateQueueBucket",
+			Work: func(pl interface{}) int { return 0 },
+		}
+		if err := q.Start(); err != nil {
+			t.Errorf("Unexpected error: %s", err.Error())
+		}
+		q.Close()
+		q = &payloadqueue.RateQueue{
+			Tag:  "RateQueueBucket",
+			Work: func(pl interface{}) int { return 0 },
+		}
+		if err := q.Start(); err == nil {
+			t.Errorf("Expected an error for a zero rate")
+		}
+	})
+}
+
+func TestRateQMaxInFlight(t *testing.T) {
+	for _, maxInFlight := range []int{0, 3} {
+		t.Run("MaxInFlight "+strconv.Itoa(maxInFlight), func(t *testing.T) {
+			var runMutex sync.Mutex
+			running, most, runtimes := 0, 0, 0
+
+			q := &payloadqueue.RateQueue{
+				RequestsPerSecond: 100,
+				MaxInFlight:       maxInFlight,
+				Tag:               "RateQueueInFlight",
+				Work: func(pl interface{}) int {
+					runMutex.Lock()
+					running++
+					runtimes++
+					if running > most {
+						most = running
+					}
+					runMutex.Unlock()
+					time.Sleep(100 * time.Millisecond)
+					runMutex.Lock()
+					running--
+					runMutex.Unlock()
+					return 0
+				},
+			}
+			q.Start()
+			for i := 0; i < 6; i++ {
+				q.Append(payloadqueue.Payload{Id: strconv.Itoa(i)})
+			}
+			time.Sleep(250 * time.Millisecond)
+			q.Close()
+
+			expected := maxInFlight
+			if expected == 0 {
+				expected = 1
+			}
+			runMutex.Lock()
+			if most != expected {
+				t.Errorf("Expected at most %d Work calls at once, got %d", expected, most)
+			}
+			if runtimes != 6 {
+				t.Errorf("Expected runtimes to be 6, got %d", runtimes)
+			}
+			runMutex.Unlock()
+		})
+	}
+}
+
+func TestRateQRetryAfter(t *testing.T) {
+	t.Run("RetryAfter holds back the queue and re-queues the payload", func(t *testing.T) {
+		var runMutex sync.Mutex
+		var runs []string
+		var times []time.Time
+
+		q := &payloadqueue.RateQueue{
+			RequestsPerSecond: 100,
+			Tag:               "RateQueueRetryAfter",
+			WorkResult: func(ctx context.Context, pl interface{}) payloadqueue.Result {
+				runMutex.Lock()
+				defer runMutex.Unlock()
+				runs = append(runs, pl.(string))
+				times = append(times, time.Now())
+				if len(runs) == 1 {
+					return payloadqueue.Result{Code: 429, RetryAfter: 300 * time.Millisecond}
+				}
+				return payloadqueue.Result{}
+			},
+		}
+		q.Start()
+		q.Append(payloadqueue.Payload{Id: "1", Data: "a"})
+		q.Append(payloadqueue.Payload{Id: "2", Data: "b"})
+
+		time.Sleep(200 * time.Millisecond)
+		runMutex.Lock()
+		if len(runs) != 1 {
+			t.Errorf("Expected the queue to be held back after the first run, got %v", runs)
+		}
+		runMutex.Unlock()
+
+		time.Sleep(300 * time.Millisecond)
+		q.Close()
+		runMutex.Lock()
+		if strings.Join(runs, "") != "aab" {
+			t.Errorf("Expected a to be run again before b, got %v", runs)
+		}
+		if len(times) > 1 && times[1].Sub(times[0]) < 300*time.Millisecond {
+			t.Errorf("Expected the second run after the RetryAfter, got %s", times[1].Sub(times[0]))
+		}
+		runMutex.Unlock()
+	})
+
+	t.Run("RetryAfter attempts are capped by the Retry policy", func(t *testing.T) {
+		var runs int32
+		dl := &payloadqueue.MemoryDeadLetter[interface{}]{}
+		q := &payloadqueue.RateQueue{
+			RequestsPerSecond: 1000,
+			Tag:               "RateQueueRetryAfter",
+			Retry:             payloadqueue.ExponentialBackoff{MaxAttempts: 2},
+			DeadLetter:        dl,
+			WorkResult: func(ctx context.Context, pl interface{}) payloadqueue.Result {
+				atomic.AddInt32(&runs, 1)
+				return payloadqueue.Result{Code: 429, RetryAfter: 10 * time.Millisecond}
+			},
+		}
+		q.Start()
+		q.Append(payloadqueue.Payload{Id: "1", Data: "a"})
+		time.Sleep(100 * time.Millisecond)
+		q.Close()
+		if n := atomic.LoadInt32(&runs); n != 2 {
+			t.Errorf("Expected 2 attempts, got %d", n)
+		}
+		if dl.Size() != 1 {
+			t.Errorf("Expected the payload to be dead-lettered, got %d", dl.Size())
+		}
+	})
+
+	t.Run("Close does not wait for a RetryAfter", func(t *testing.T) {
+		dir := t.TempDir()
+		q := &payloadqueue.RateQueue{
+			RequestsPerSecond: 1000,
+			Tag:               "RateQueueRetryAfter",
+			WAL:               &payloadqueue.WAL{Dir: dir},
+			WorkResult: func(ctx context.Context, pl interface{}) payloadqueue.Result {
+				return payloadqueue.Result{Code: 429, RetryAfter: time.Hour}
+			},
+		}
+		q.Start()
+		q.Append(payloadqueue.Payload{Id: "1", Data: "a"})
+		time.Sleep(20 * time.Millisecond)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := q.CloseContext(ctx); err != nil {
+			t.Errorf("Expected Close to return without waiting, got %v", err)
+		}
+		wal := &payloadqueue.WAL{Dir: dir}
+		pls, _ := wal.Open()
+		wal.Close()
+		if len(pls) != 1 {
+			t.Errorf("Expected the payload to stay in the WAL, got %+v", pls)
+		}
+	})
+}
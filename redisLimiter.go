@@ -0,0 +1,91 @@
+package payloadqueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// RedisScripter is the part of a Redis client used by RedisLimiter. Any client can be
+// adapted with RedisEvalFunc, e.g. for go-redis:
+//
+//	payloadqueue.RedisEvalFunc(func(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+//		return rdb.Eval(ctx, script, keys, args...).Result()
+//	})
+type RedisScripter interface {
+	Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error)
+}
+
+// RedisEvalFunc to use a function as a RedisScripter
+type RedisEvalFunc func(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error)
+
+// Eval to run the script
+func (f RedisEvalFunc) Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+	return f(ctx, script, keys, args...)
+}
+
+// RedisLimiter is a token bucket kept in Redis, so every RateQueue using the same Key
+// shares one rate whichever process it runs in. The bucket is refilled by a script with
+// the Redis server clock, so the clocks of the replicas do not matter.
+type RedisLimiter struct {
+	Client RedisScripter
+	Key    string        // shared by the RateQueues of all the replicas
+	Rate   float64       // requests per Per across all the replicas
+	Per    time.Duration // Default is 1 second
+	Burst  int           // Default is 1
+}
+
+// redisTokenBucket refills the bucket in KEYS[1] at ARGV[1] tokens per second up to
+// ARGV[2] and takes a token. It returns 0 or the microseconds until the next token.
+const redisTokenBucket = `
+if redis.replicate_commands then redis.replicate_commands() end
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local time = redis.call('TIME')
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1]) or burst
+local ts = tonumber(state[2]) or now
+if now > ts then
+	tokens = math.min(burst, tokens + (now - ts) * rate / 1000000)
+end
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+else
+	wait = math.ceil((1 - tokens) * 1000000 / rate)
+end
+redis.call('HMSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(now))
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst * 1000 / rate) + 1000)
+return wait
+`
+
+// Take to consume a token from the shared bucket
+func (l *RedisLimiter) Take(ctx context.Context) (time.Duration, error) {
+	if l.Client == nil || l.Key == "" {
+		return 0, errors.New("the RedisLimiter Client and Key are required")
+	}
+	per := l.Per
+	if per == 0 {
+		per = time.Second
+	}
+	if l.Rate <= 0 || per < 0 {
+		return 0, errors.New("the RedisLimiter cannot have zero requests/second")
+	}
+	burst := l.Burst
+	if burst < 1 {
+		burst = 1
+	}
+	rate := strconv.FormatFloat(l.Rate/per.Seconds(), 'f', -1, 64)
+	reply, err := l.Client.Eval(ctx, redisTokenBucket, []string{l.Key}, rate, strconv.Itoa(burst))
+	if err != nil {
+		return 0, err
+	}
+	wait, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("unexpected reply from Redis: %v", reply)
+	}
+	return time.Duration(wait) * time.Microsecond, nil
+}
@@ -0,0 +1,110 @@
+package payloadqueue_test
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/sam-ish/payloadqueue"
+)
+
+// redisClient to start an in-process Redis and return a RedisScripter for it
+func redisClient(t *testing.T) payloadqueue.RedisScripter {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return payloadqueue.RedisEvalFunc(func(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+		return rdb.Eval(ctx, script, keys, args...).Result()
+	})
+}
+
+func TestRedisLimiter(t *testing.T) {
+	t.Run("Take hands out Burst tokens and then waits", func(t *testing.T) {
+		l := &payloadqueue.RedisLimiter{Client: redisClient(t), Key: "limit", Rate: 10, Burst: 2}
+		for i := 0; i < 2; i++ {
+			if wait, err := l.Take(context.Background()); err != nil || wait != 0 {
+				t.Errorf("Expected token %d straight away, got %s and %v", i, wait, err)
+			}
+		}
+		wait, err := l.Take(context.Background())
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		if wait <= 0 || wait > 100*time.Millisecond {
+			t.Errorf("Expected to wait up to 100ms for the next token, got %s", wait)
+		}
+	})
+
+	t.Run("Replicas share the rate", func(t *testing.T) {
+		client := redisClient(t)
+		var worked int64
+		queues := make([]*payloadqueue.RateQueue, 3)
+		for i := range queues {
+			queues[i] = &payloadqueue.RateQueue{
+				Tag:     "RateQueueRedis" + strconv.Itoa(i),
+				Limiter: &payloadqueue.RedisLimiter{Client: client, Key: "shared", Rate: 20},
+				Work: func(pl interface{}) int {
+					atomic.AddInt64(&worked, 1)
+					return 0
+				},
+				DiscardOnClose: true,
+			}
+			if err := queues[i].Start(); err != nil {
+				t.Fatalf("Unexpected error: %s", err.Error())
+			}
+			for j := 0; j < 20; j++ {
+				queues[i].Append(payloadqueue.Payload{Id: strconv.Itoa(j), Data: j})
+			}
+		}
+		time.Sleep(500 * time.Millisecond)
+		for _, q := range queues {
+			q.Close()
+		}
+		// a token up front and 20 per second across the three queues
+		if n := atomic.LoadInt64(&worked); n < 8 || n > 13 {
+			t.Errorf("Expected around 11 Work calls across the replicas, got %d", n)
+		}
+	})
+
+	t.Run("Close keeps to the shared rate", func(t *testing.T) {
+		var worked int64
+		q := &payloadqueue.RateQueue{
+			Tag:     "RateQueueRedisClose",
+			Limiter: &payloadqueue.RedisLimiter{Client: redisClient(t), Key: "shared", Rate: 20},
+			Work: func(pl interface{}) int {
+				atomic.AddInt64(&worked, 1)
+				return 0
+			},
+		}
+		if err := q.Start(); err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		for j := 0; j < 20; j++ {
+			q.Append(payloadqueue.Payload{Id: strconv.Itoa(j), Data: j})
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+		defer cancel()
+		if err := q.CloseContext(ctx); err != context.DeadlineExceeded {
+			t.Errorf("Expected the flush to outlast the deadline, got %v", err)
+		}
+		// a token up front and 20 per second
+		if n := atomic.LoadInt64(&worked); n > 8 {
+			t.Errorf("Expected around 6 Work calls within the deadline, got %d", n)
+		}
+	})
+
+	t.Run("Adaptive cannot be used with a Limiter", func(t *testing.T) {
+		q := &payloadqueue.RateQueue{
+			Limiter:  &payloadqueue.RedisLimiter{Client: redisClient(t), Key: "limit", Rate: 10},
+			Adaptive: &payloadqueue.Adaptive{MaxRate: 10},
+			Work:     func(pl interface{}) int { return 0 },
+		}
+		if err := q.Start(); err == nil {
+			t.Errorf("Expected an error for Adaptive with a Limiter")
+		}
+	})
+}
@@ -0,0 +1,73 @@
+package payloadqueue
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy to decide if a payload that failed with a non-zero Work result is pushed
+// again, and how long to wait before doing so. attempts is the number of times Work has
+// been called with the payload so far.
+type RetryPolicy interface {
+	Retry(attempts int, result int) (time.Duration, bool)
+}
+
+// ExponentialBackoff is a RetryPolicy that doubles (by Multiplier) the delay after every
+// failed attempt until MaxAttempts is reached.
+type ExponentialBackoff struct {
+	MaxAttempts  int                   // including the first attempt. Default is 3
+	InitialDelay time.Duration         // Default is 1 second
+	MaxDelay     time.Duration         // Default is 1 minute
+	Multiplier   float64               // Default is 2
+	Jitter       float64               // 0 to 1. Fraction of the delay that is randomised.
+	Retryable    func(result int) bool // nil treats every non-zero result as retryable
+}
+
+// Retry to return the backoff delay before the next attempt.
+func (b ExponentialBackoff) Retry(attempts int, result int) (time.Duration, bool) {
+	if result == 0 {
+		return 0, false
+	}
+	if b.Retryable != nil && !b.Retryable(result) {
+		return 0, false
+	}
+	maxAttempts := b.MaxAttempts
+	if maxAttempts == 0 {
+		maxAttempts = 3
+	}
+	if attempts >= maxAttempts {
+		return 0, false
+	}
+	initial, maxDelay, multiplier := b.InitialDelay, b.MaxDelay, b.Multiplier
+	if initial == 0 {
+		initial = time.Second
+	}
+	if maxDelay == 0 {
+		maxDelay = time.Minute
+	}
+	if multiplier < 1 {
+		multiplier = 2
+	}
+	delay := float64(initial) * math.Pow(multiplier, float64(attempts-1))
+	if delay > float64(maxDelay) {
+		delay = float64(maxDelay)
+	}
+	if b.Jitter > 0 {
+		delay -= delay * math.Min(b.Jitter, 1) * rand.Float64()
+	}
+	return time.Duration(delay), true
+}
+
+// RetryCodes to return a Retryable classifier that only retries the given result codes.
+// Every other non-zero result is treated as a permanent failure.
+func RetryCodes(codes ...int) func(int) bool {
+	return func(result int) bool {
+		for _, c := range codes {
+			if c == result {
+				return true
+			}
+		}
+		return false
+	}
+}
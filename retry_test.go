@@ -0,0 +1,126 @@
+package payloadqueue_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sam-ish/payloadqueue"
+)
+
+func TestExponentialBackoff(t *testing.T) {
+	t.Run("Delay grows by Multiplier", func(t *testing.T) {
+		b := payloadqueue.ExponentialBackoff{
+			MaxAttempts:  5,
+			InitialDelay: 100 * time.Millisecond,
+			MaxDelay:     time.Second,
+			Multiplier:   2,
+		}
+		expected := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond}
+		for i, e := range expected {
+			delay, ok := b.Retry(i+1, 1)
+			if !ok {
+				t.Errorf("Expected attempt %d to be retried", i+1)
+			}
+			if delay != e {
+				t.Errorf("Expected delay %s for attempt %d, got %s", e, i+1, delay)
+			}
+		}
+		if _, ok := b.Retry(5, 1); ok {
+			t.Errorf("Expected no retry after MaxAttempts")
+		}
+	})
+
+	t.Run("Delay is capped by MaxDelay", func(t *testing.T) {
+		b := payloadqueue.ExponentialBackoff{
+			MaxAttempts:  10,
+			InitialDelay: time.Second,
+			MaxDelay:     3 * time.Second,
+		}
+		if delay, _ := b.Retry(5, 1); delay != 3*time.Second {
+			t.Errorf("Expected delay of 3s, got %s", delay)
+		}
+	})
+
+	t.Run("Jitter stays within the delay", func(t *testing.T) {
+		b := payloadqueue.ExponentialBackoff{
+			InitialDelay: time.Second,
+			Jitter:       0.5,
+		}
+		for i := 0; i < 100; i++ {
+			delay, _ := b.Retry(1, 1)
+			if delay < 500*time.Millisecond || delay > time.Second {
+				t.Errorf("Expected delay between 500ms and 1s, got %s", delay)
+			}
+		}
+	})
+
+	t.Run("Permanent result codes are not retried", func(t *testing.T) {
+		b := payloadqueue.ExponentialBackoff{
+			Retryable: payloadqueue.RetryCodes(429, 503),
+		}
+		if _, ok := b.Retry(1, 503); !ok {
+			t.Errorf("Expected 503 to be retried")
+		}
+		if _, ok := b.Retry(1, 400); ok {
+			t.Errorf("Expected 400 not to be retried")
+		}
+		if _, ok := b.Retry(1, 0); ok {
+			t.Errorf("Expected success not to be retried")
+		}
+	})
+}
+
+func TestRetryOnClose(t *testing.T) {
+	t.Run("Queue keeps the pending retries in the WAL", func(t *testing.T) {
+		dir := t.TempDir()
+		dl := &payloadqueue.MemoryDeadLetter[interface{}]{}
+		q := &payloadqueue.Queue{
+			MaxSize:    1,
+			MaxAge:     10,
+			Tag:        "QueueRetryClose",
+			Retry:      payloadqueue.ExponentialBackoff{InitialDelay: time.Hour},
+			DeadLetter: dl,
+			WAL:        &payloadqueue.WAL{Dir: dir},
+			Work:       func(pls []interface{}) int { return 1 },
+		}
+		q.Start()
+		q.Append(payloadqueue.Payload{Id: "1", Data: 1})
+		time.Sleep(20 * time.Millisecond)
+		q.Close()
+		if dl.Size() != 0 {
+			t.Errorf("Expected the pending retry not to be dead-lettered, got %d", dl.Size())
+		}
+		wal := &payloadqueue.WAL{Dir: dir}
+		pls, _ := wal.Open()
+		wal.Close()
+		if len(pls) != 1 || pls[0].Attempts != 1 {
+			t.Errorf("Expected the pending retry to stay in the WAL, got %+v", pls)
+		}
+	})
+
+	t.Run("RateQueue keeps the pending retries in the WAL", func(t *testing.T) {
+		dir := t.TempDir()
+		dl := &payloadqueue.MemoryDeadLetter[interface{}]{}
+		q := &payloadqueue.RateQueue{
+			Rate:       1000,
+			Tag:        "RateQueueRetryClose",
+			Retry:      payloadqueue.ExponentialBackoff{InitialDelay: time.Hour},
+			DeadLetter: dl,
+			WAL:        &payloadqueue.WAL{Dir: dir},
+			Work:       func(pl interface{}) int { return 1 },
+		}
+		q.Start()
+		q.Append(payloadqueue.Payload{Id: "1", Data: 1})
+		time.Sleep(20 * time.Millisecond)
+		q.Close()
+		if dl.Size() != 0 {
+			t.Errorf("Expected the pending retry not to be dead-lettered, got %d", dl.Size())
+		}
+		wal := &payloadqueue.WAL{Dir: dir}
+		pls, _ := wal.Open()
+		wal.Close()
+		if len(pls) != 1 || pls[0].Attempts != 1 {
+			t.Errorf("Expected the pending retry to stay in the WAL, got %+v", pls)
+		}
+	})
+}
@@ -0,0 +1,259 @@
+package payloadqueue
+
+import (
+	"container/heap"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// schedule holds the payloads appended with a NotBefore in the future until they are due.
+// A single timer is set for the earliest payload.
+type schedule[T any] struct {
+	mutex   sync.Mutex
+	heap    scheduleHeap[T]
+	seq     int64
+	timer   Timer
+	due     func(TypedPayload[T]) // called with each payload once due
+	clock   Clock
+	stopped bool
+}
+
+// scheduled is a payload in the schedule heap. seq keeps the append order for equal times.
+type scheduled[T any] struct {
+	p   TypedPayload[T]
+	seq int64
+}
+
+// scheduleHeap orders the payloads by NotBefore for container/heap
+type scheduleHeap[T any] []scheduled[T]
+
+func (h scheduleHeap[T]) Len() int { return len(h) }
+func (h scheduleHeap[T]) Less(i, j int) bool {
+	if h[i].p.NotBefore.Equal(h[j].p.NotBefore) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].p.NotBefore.Before(h[j].p.NotBefore)
+}
+func (h scheduleHeap[T]) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *scheduleHeap[T]) Push(x interface{}) { *h = append(*h, x.(scheduled[T])) }
+func (h *scheduleHeap[T]) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// push to hold the payload until its NotBefore. It returns false once the schedule is stopped.
+func (s *schedule[T]) push(p TypedPayload[T], due func(TypedPayload[T])) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.stopped {
+		return false
+	}
+	s.due = due
+	s.seq++
+	heap.Push(&s.heap, scheduled[T]{p: p, seq: s.seq})
+	s.reset(clockOr(s.clock).Now())
+	return true
+}
+
+// fire to hand the due payloads over and set the timer for the next one
+func (s *schedule[T]) fire() {
+	s.mutex.Lock()
+	if s.stopped {
+		s.mutex.Unlock()
+		return
+	}
+	now := clockOr(s.clock).Now()
+	var due []TypedPayload[T]
+	for len(s.heap) > 0 && !s.heap[0].p.NotBefore.After(now) {
+		due = append(due, heap.Pop(&s.heap).(scheduled[T]).p)
+	}
+	s.reset(now)
+	handle := s.due
+	s.mutex.Unlock()
+	for _, p := range due {
+		handle(p)
+	}
+}
+
+// reset to set the timer for the earliest payload. Must be called with mutex held.
+func (s *schedule[T]) reset(now time.Time) {
+	if len(s.heap) == 0 {
+		return
+	}
+	wait := s.heap[0].p.NotBefore.Sub(now)
+	if s.timer == nil {
+		s.timer = clockOr(s.clock).AfterFunc(wait, s.fire)
+		return
+	}
+	s.timer.Reset(wait)
+}
+
+// stop to stop the timer and return the payloads that are not due yet, in order
+func (s *schedule[T]) stop() []TypedPayload[T] {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.stopped = true
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+	pls := make([]TypedPayload[T], 0, len(s.heap))
+	for len(s.heap) > 0 {
+		pls = append(pls, heap.Pop(&s.heap).(scheduled[T]).p)
+	}
+	return pls
+}
+
+// len to return the number of payloads waiting to be due
+func (s *schedule[T]) len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return len(s.heap)
+}
+
+// AppendAt to add a Payload that is not batched before t
+func (q *TypedQueue[T]) AppendAt(p TypedPayload[T], t time.Time) error {
+	p.NotBefore = t
+	return q.Append(p)
+}
+
+// AppendAfter to add a Payload that is not batched before d has elapsed
+func (q *TypedQueue[T]) AppendAfter(p TypedPayload[T], d time.Duration) error {
+	return q.AppendAt(p, q.clock().Now().Add(d))
+}
+
+// Scheduled to return the number of payloads waiting for their NotBefore
+func (q *TypedQueue[T]) Scheduled() int {
+	return q.schedule.len()
+}
+
+// busyBackoff is how long a due payload refused by OverflowReject waits in the schedule
+// before it is appended again
+const busyBackoff = time.Second
+
+// due to append the scheduled payload once its NotBefore is reached. A payload refused
+// because the workers are busy is put back in the schedule for busyBackoff.
+func (q *TypedQueue[T]) due(p TypedPayload[T]) {
+	switch err := q.add(p, true); err {
+	case nil:
+	case errBusy:
+		p.NotBefore = q.clock().Now().Add(busyBackoff)
+		if !q.schedule.push(p, q.due) {
+			q.unschedule([]TypedPayload[T]{p})
+			return
+		}
+		q.event(Event{Kind: EventScheduled, Level: LevelWarn, Ids: []string{p.Id}, Message: "Payload Scheduled [id]: " + p.Id + " @ " + p.NotBefore.String() + ". " + errBusy.Error()})
+	default:
+		q.unschedule([]TypedPayload[T]{p})
+	}
+}
+
+// unschedule to keep the scheduled payloads that can no longer be appended in the WAL
+// for the next Start or else to hand them to the DeadLetter. Without either, they are
+// dropped.
+func (q *TypedQueue[T]) unschedule(pls []TypedPayload[T]) {
+	if len(pls) == 0 {
+		return
+	}
+	if q.WAL != nil {
+		q.event(Event{Kind: EventUnscheduled, Ids: payloadIds(pls), Size: len(pls), Message: "Scheduled: " + strconv.Itoa(len(pls)) + " payload(s) kept in the WAL"})
+		return
+	}
+	if q.DeadLetter == nil {
+		q.event(Event{Kind: EventUnscheduled, Level: LevelError, Ids: payloadIds(pls), Size: len(pls), Message: "Scheduled: " + strconv.Itoa(len(pls)) + " payload(s) not due are dropped"})
+		return
+	}
+	q.event(Event{Kind: EventUnscheduled, Level: LevelWarn, Ids: payloadIds(pls), Size: len(pls), Message: "Scheduled: " + strconv.Itoa(len(pls)) + " payload(s) not due are dead-lettered"})
+	for _, p := range pls {
+		q.deadLetter(p)
+	}
+}
+
+// closeSchedule to stop the schedule on Close. Without a WAL or a DeadLetter to keep
+// them, the payloads not due are buffered to be flushed with the queue.
+func (q *TypedQueue[T]) closeSchedule() {
+	pls := q.schedule.stop()
+	if len(pls) == 0 || q.WAL != nil || q.DeadLetter != nil {
+		q.unschedule(pls)
+		return
+	}
+	var h held
+	var dropped []TypedPayload[T]
+	q.payloadMutex.Lock()
+	for _, p := range pls {
+		pt, err := q.partition(&h, q.key(p.Data))
+		if err != nil {
+			dropped = append(dropped, p)
+			continue
+		}
+		pt.payloads = append(pt.payloads, p)
+		pt.bytes += q.size(&h, p.Data)
+	}
+	q.unlock(&h)
+	q.event(Event{Kind: EventUnscheduled, Level: LevelWarn, Ids: payloadIds(pls), Size: len(pls), Message: "Scheduled: " + strconv.Itoa(len(pls)-len(dropped)) + " payload(s) not due are flushed"})
+	q.unschedule(dropped)
+}
+
+// AppendAt to add a Payload that is not dispatched before t
+func (q *TypedRateQueue[T]) AppendAt(p TypedPayload[T], t time.Time) error {
+	p.NotBefore = t
+	return q.Append(p)
+}
+
+// AppendAfter to add a Payload that is not dispatched before d has elapsed
+func (q *TypedRateQueue[T]) AppendAfter(p TypedPayload[T], d time.Duration) error {
+	return q.AppendAt(p, q.clock().Now().Add(d))
+}
+
+// Scheduled to return the number of payloads waiting for their NotBefore
+func (q *TypedRateQueue[T]) Scheduled() int {
+	return q.schedule.len()
+}
+
+// due to append the scheduled payload once its NotBefore is reached
+func (q *TypedRateQueue[T]) due(p TypedPayload[T]) {
+	if err := q.add(p, true); err != nil {
+		q.unschedule([]TypedPayload[T]{p})
+	}
+}
+
+// unschedule to keep the scheduled payloads that can no longer be appended in the WAL
+// for the next Start or else to hand them to the DeadLetter. Without either, they are
+// dropped.
+func (q *TypedRateQueue[T]) unschedule(pls []TypedPayload[T]) {
+	if len(pls) == 0 {
+		return
+	}
+	if q.WAL != nil {
+		q.event(Event{Kind: EventUnscheduled, Ids: payloadIds(pls), Size: len(pls), Message: "Scheduled: " + strconv.Itoa(len(pls)) + " payload(s) kept in the WAL"})
+		return
+	}
+	if q.DeadLetter == nil {
+		q.event(Event{Kind: EventUnscheduled, Level: LevelError, Ids: payloadIds(pls), Size: len(pls), Message: "Scheduled: " + strconv.Itoa(len(pls)) + " payload(s) not due are dropped"})
+		return
+	}
+	q.event(Event{Kind: EventUnscheduled, Level: LevelWarn, Ids: payloadIds(pls), Size: len(pls), Message: "Scheduled: " + strconv.Itoa(len(pls)) + " payload(s) not due are dead-lettered"})
+	for _, p := range pls {
+		q.deadLetter(p)
+	}
+}
+
+// closeSchedule to stop the schedule on Close. Without a WAL or a DeadLetter to keep
+// them, the payloads not due are flushed with the queue unless DiscardOnClose is set.
+func (q *TypedRateQueue[T]) closeSchedule() {
+	pls := q.schedule.stop()
+	if len(pls) == 0 || q.WAL != nil || q.DeadLetter != nil || q.DiscardOnClose {
+		q.unschedule(pls)
+		return
+	}
+	q.payloadMutex.Lock()
+	for _, p := range pls {
+		if l, err := q.lane(p); err == nil {
+			l.push(q.key(p.Data), p, false)
+		}
+	}
+	q.payloadMutex.Unlock()
+	q.event(Event{Kind: EventUnscheduled, Level: LevelWarn, Ids: payloadIds(pls), Size: len(pls), Message: "Scheduled: " + strconv.Itoa(len(pls)) + " payload(s) not due are flushed"})
+}
@@ -0,0 +1,228 @@
+package payloadqueue_test
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sam-ish/payloadqueue"
+)
+
+func TestQueueSchedule(t *testing.T) {
+	var runMutex sync.Mutex
+	worked, scheduled := 0, 0
+	m := newRecorder()
+	q := &payloadqueue.Queue{
+		MaxSize: 1,
+		MaxAge:  10,
+		Tag:     "QueueSchedule",
+		Metrics: m,
+		Events: func(e payloadqueue.Event) {
+			if e.Kind == payloadqueue.EventScheduled {
+				runMutex.Lock()
+				scheduled++
+				runMutex.Unlock()
+			}
+		},
+		Work: func(pls []interface{}) int {
+			runMutex.Lock()
+			worked += len(pls)
+			runMutex.Unlock()
+			return 0
+		},
+	}
+	q.Start()
+	q.AppendAfter(payloadqueue.Payload{Id: "later", Data: "later"}, 100*time.Millisecond)
+	if q.Scheduled() != 1 || q.Size() != 0 {
+		t.Errorf("Expected the payload to be scheduled, got %d scheduled and %d queued", q.Scheduled(), q.Size())
+	}
+	time.Sleep(50 * time.Millisecond)
+	runMutex.Lock()
+	if worked != 0 {
+		t.Errorf("Expected no Work before the payload is due, got %d", worked)
+	}
+	runMutex.Unlock()
+	time.Sleep(100 * time.Millisecond)
+	runMutex.Lock()
+	if worked != 1 {
+		t.Errorf("Expected the payload to be worked once due, got %d", worked)
+	}
+	if scheduled != 1 {
+		t.Errorf("Expected one EventScheduled, got %d", scheduled)
+	}
+	runMutex.Unlock()
+	q.Close()
+	if m.enqueued != 1 {
+		t.Errorf("Expected the scheduled payload to be enqueued once, got %d", m.enqueued)
+	}
+}
+
+func TestQueueScheduleBusy(t *testing.T) {
+	dir := t.TempDir()
+	release := make(chan struct{})
+	q := &payloadqueue.Queue{
+		MaxSize:              1,
+		MaxAge:               10,
+		MaxConcurrentBatches: 1,
+		Overflow:             payloadqueue.OverflowReject,
+		Tag:                  "QueueScheduleBusy",
+		WAL:                  &payloadqueue.WAL{Dir: dir},
+		Work: func(pls []interface{}) int {
+			<-release
+			return 0
+		},
+	}
+	q.Start()
+	q.Append(payloadqueue.Payload{Id: "busy", Data: "busy"})
+	q.AppendAfter(payloadqueue.Payload{Id: "later", Data: "later"}, 20*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	if q.Scheduled() != 1 {
+		t.Errorf("Expected the refused payload back in the schedule, got %d scheduled", q.Scheduled())
+	}
+	if q.WAL.Size() != 2 {
+		t.Errorf("Expected the refused payload to stay in the WAL, got %d pending", q.WAL.Size())
+	}
+	close(release)
+	q.Close()
+	wal := &payloadqueue.WAL{Dir: dir}
+	pls, _ := wal.Open()
+	wal.Close()
+	if len(pls) != 1 || pls[0].Id != "later" {
+		t.Errorf("Expected the scheduled payload to be replayed, got %+v", pls)
+	}
+}
+
+func TestRateQSchedule(t *testing.T) {
+	t.Run("Payloads are dispatched in due order", func(t *testing.T) {
+		var runMutex sync.Mutex
+		var order []string
+		q := &payloadqueue.RateQueue{
+			Rate: 1000,
+			Tag:  "RateQueueSchedule",
+			Work: func(pl interface{}) int {
+				runMutex.Lock()
+				order = append(order, pl.(string))
+				runMutex.Unlock()
+				return 0
+			},
+		}
+		q.Start()
+		q.AppendAfter(payloadqueue.Payload{Id: "b", Data: "b"}, 100*time.Millisecond)
+		q.AppendAt(payloadqueue.Payload{Id: "a", Data: "a"}, time.Now().Add(50*time.Millisecond))
+		q.Append(payloadqueue.Payload{Id: "now", Data: "now"})
+		time.Sleep(200 * time.Millisecond)
+		runMutex.Lock()
+		if got := strings.Join(order, ","); got != "now,a,b" {
+			t.Errorf("Expected now,a,b, got %s", got)
+		}
+		runMutex.Unlock()
+		q.Close()
+	})
+
+	t.Run("A scheduled payload is enqueued once", func(t *testing.T) {
+		m := newRecorder()
+		q := &payloadqueue.RateQueue{
+			Rate:    1000,
+			Tag:     "RateQueueScheduleMetrics",
+			Metrics: m,
+			Work:    func(pl interface{}) int { return 0 },
+		}
+		q.Start()
+		q.AppendAfter(payloadqueue.Payload{Id: "later", Data: "later"}, 50*time.Millisecond)
+		time.Sleep(100 * time.Millisecond)
+		q.Close()
+		m.mutex.Lock()
+		defer m.mutex.Unlock()
+		if m.enqueued != 1 || m.worked != 1 {
+			t.Errorf("Expected the payload to be enqueued and worked once, got %d and %d", m.enqueued, m.worked)
+		}
+	})
+	t.Run("Close dead-letters the payloads not due", func(t *testing.T) {
+		dl := &payloadqueue.MemoryDeadLetter[interface{}]{}
+		q := &payloadqueue.RateQueue{
+			Rate:       1000,
+			Tag:        "RateQueueSchedule",
+			DeadLetter: dl,
+			Work:       func(pl interface{}) int { return 0 },
+		}
+		q.Start()
+		q.AppendAfter(payloadqueue.Payload{Id: "later", Data: "later"}, time.Hour)
+		q.Close()
+		if dl.Size() != 1 {
+			t.Errorf("Expected the scheduled payload in the dead letter, got %d", dl.Size())
+		}
+		if err := q.AppendAfter(payloadqueue.Payload{Id: "closed", Data: "closed"}, time.Hour); err == nil {
+			t.Errorf("Expected an error once closed")
+		}
+	})
+
+	t.Run("Close flushes the payloads not due without a DeadLetter", func(t *testing.T) {
+		var runMutex sync.Mutex
+		var worked []string
+		q := &payloadqueue.RateQueue{
+			Rate: 1000,
+			Tag:  "RateQueueSchedule",
+			Work: func(pl interface{}) int {
+				runMutex.Lock()
+				worked = append(worked, pl.(string))
+				runMutex.Unlock()
+				return 0
+			},
+		}
+		q.Start()
+		q.AppendAfter(payloadqueue.Payload{Id: "later", Data: "later"}, time.Hour)
+		q.Close()
+		runMutex.Lock()
+		if len(worked) != 1 {
+			t.Errorf("Expected the scheduled payload to be flushed, got %v", worked)
+		}
+		runMutex.Unlock()
+	})
+
+	t.Run("Close reports the payloads not due that are dropped", func(t *testing.T) {
+		var dropped []payloadqueue.Event
+		q := &payloadqueue.RateQueue{
+			Rate:           1000,
+			Tag:            "RateQueueSchedule",
+			DiscardOnClose: true,
+			Work:           func(pl interface{}) int { return 0 },
+			Events: func(e payloadqueue.Event) {
+				if e.Kind == payloadqueue.EventUnscheduled {
+					dropped = append(dropped, e)
+				}
+			},
+		}
+		q.Start()
+		q.AppendAfter(payloadqueue.Payload{Id: "later", Data: "later"}, time.Hour)
+		q.Close()
+		if len(dropped) != 1 || dropped[0].Level != payloadqueue.LevelError || !strings.Contains(dropped[0].Message, "dropped") {
+			t.Errorf("Expected an error event for the dropped payload, got %v", dropped)
+		}
+	})
+
+	t.Run("Close keeps the payloads not due in the WAL", func(t *testing.T) {
+		dir := t.TempDir()
+		q := &payloadqueue.RateQueue{
+			Rate: 1000,
+			Tag:  "RateQueueSchedule",
+			WAL:  &payloadqueue.WAL{Dir: dir},
+			Work: func(pl interface{}) int { return 0 },
+		}
+		q.Start()
+		q.AppendAfter(payloadqueue.Payload{Id: "later", Data: "later"}, time.Hour)
+		q.Close()
+
+		q = &payloadqueue.RateQueue{
+			Rate: 1000,
+			Tag:  "RateQueueSchedule",
+			WAL:  &payloadqueue.WAL{Dir: dir},
+			Work: func(pl interface{}) int { return 0 },
+		}
+		q.Start()
+		if q.Scheduled() != 1 {
+			t.Errorf("Expected the replayed payload to be scheduled again, got %d", q.Scheduled())
+		}
+		q.Close()
+	})
+}
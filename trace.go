@@ -0,0 +1,61 @@
+package payloadqueue
+
+import "context"
+
+// TraceContext is the span context of a producer, captured at AppendContext in a
+// propagation format such as the W3C traceparent header, so it can be kept in the WAL
+type TraceContext map[string]string
+
+// Tracer links the Work calls back to the spans that appended their payloads. The
+// otel subpackage implements it with OpenTelemetry.
+type Tracer interface {
+	// Capture to return the span context of ctx, or nil if there is none
+	Capture(ctx context.Context) TraceContext
+	// Start to begin the span of a Work call with size payloads, linked to the captured
+	// contexts. The returned func ends it with the result code of each payload.
+	Start(ctx context.Context, tag string, links []TraceContext, size int) (context.Context, func(results []int))
+}
+
+// AppendContext to add a Payload, keeping the span context of ctx for the Tracer
+func (q *TypedQueue[T]) AppendContext(ctx context.Context, p TypedPayload[T]) error {
+	if q.Tracer != nil {
+		p.Trace = q.Tracer.Capture(ctx)
+	}
+	return q.Append(p)
+}
+
+// trace to return the context passed to Work and the func ending its span
+func (q *TypedQueue[T]) trace(pls []TypedPayload[T]) (context.Context, func([]int)) {
+	return startTrace(q.ctx, q.Tracer, q.Tag, pls)
+}
+
+// AppendContext to add a Payload, keeping the span context of ctx for the Tracer
+func (q *TypedRateQueue[T]) AppendContext(ctx context.Context, p TypedPayload[T]) error {
+	if q.Tracer != nil {
+		p.Trace = q.Tracer.Capture(ctx)
+	}
+	return q.Append(p)
+}
+
+// trace to return the context passed to Work and the func ending its span
+func (q *TypedRateQueue[T]) trace(pls []TypedPayload[T]) (context.Context, func([]int)) {
+	return startTrace(q.ctx, q.Tracer, q.Tag, pls)
+}
+
+// startTrace to start the span of a Work call linked to the producers of the payloads.
+// The queue context is used as the parent so CloseContext still cancels Work.
+func startTrace[T any](ctx context.Context, tracer Tracer, tag string, pls []TypedPayload[T]) (context.Context, func([]int)) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if tracer == nil {
+		return ctx, func([]int) {}
+	}
+	var links []TraceContext
+	for _, p := range pls {
+		if len(p.Trace) > 0 {
+			links = append(links, p.Trace)
+		}
+	}
+	return tracer.Start(ctx, tag, links, len(pls))
+}
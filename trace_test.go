@@ -0,0 +1,92 @@
+package payloadqueue_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sam-ish/payloadqueue"
+)
+
+type spanKey struct{}
+
+// stubTracer captures the "span" value of the context and records the links of each Work call
+type stubTracer struct {
+	mutex sync.Mutex
+	links [][]string
+}
+
+func (s *stubTracer) Capture(ctx context.Context) payloadqueue.TraceContext {
+	span, _ := ctx.Value(spanKey{}).(string)
+	if span == "" {
+		return nil
+	}
+	return payloadqueue.TraceContext{"span": span}
+}
+
+func (s *stubTracer) Start(ctx context.Context, tag string, links []payloadqueue.TraceContext, size int) (context.Context, func([]int)) {
+	var spans []string
+	for _, l := range links {
+		spans = append(spans, l["span"])
+	}
+	s.mutex.Lock()
+	s.links = append(s.links, spans)
+	s.mutex.Unlock()
+	return context.WithValue(ctx, spanKey{}, tag), func([]int) {}
+}
+
+func TestQueueTrace(t *testing.T) {
+	tracer := &stubTracer{}
+	var parent string
+	q := &payloadqueue.Queue{
+		MaxSize: 2,
+		MaxAge:  1000,
+		Tag:     "QueueTrace",
+		Tracer:  tracer,
+		WorkContext: func(ctx context.Context, pls []interface{}) int {
+			parent, _ = ctx.Value(spanKey{}).(string)
+			return 0
+		},
+	}
+	q.Start()
+	q.AppendContext(context.WithValue(context.Background(), spanKey{}, "a"), payloadqueue.Payload{Id: "1", Data: 1})
+	q.AppendContext(context.Background(), payloadqueue.Payload{Id: "2", Data: 2})
+	time.Sleep(50 * time.Millisecond)
+	q.Close()
+
+	tracer.mutex.Lock()
+	defer tracer.mutex.Unlock()
+	if len(tracer.links) != 1 || len(tracer.links[0]) != 1 || tracer.links[0][0] != "a" {
+		t.Errorf("Expected a batch linked to span a, got %v", tracer.links)
+	}
+	if parent != "QueueTrace" {
+		t.Errorf("Expected Work to get the batch span context, got %q", parent)
+	}
+}
+
+func TestRateQTraceWAL(t *testing.T) {
+	dir := t.TempDir()
+	tracer := &stubTracer{}
+	q := &payloadqueue.RateQueue{
+		Rate:           1000,
+		Tag:            "RateQueueTrace",
+		Tracer:         tracer,
+		WAL:            &payloadqueue.WAL{Dir: dir},
+		DiscardOnClose: true,
+		Work:           func(pl interface{}) int { return 0 },
+	}
+	q.Start()
+	q.AppendContext(context.WithValue(context.Background(), spanKey{}, "a"), payloadqueue.Payload{Id: "1", Data: 1, NotBefore: time.Now().Add(time.Hour)})
+	q.Close()
+
+	wal := &payloadqueue.WAL{Dir: dir}
+	pls, err := wal.Open()
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err.Error())
+	}
+	wal.Close()
+	if len(pls) != 1 || pls[0].Trace["span"] != "a" {
+		t.Errorf("Expected the trace context to be kept in the WAL, got %v", pls)
+	}
+}
@@ -0,0 +1,375 @@
+package payloadqueue
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Codec to encode and decode the payload Data written to a WAL.
+type Codec[T any] interface {
+	Encode(T) ([]byte, error)
+	Decode([]byte) (T, error)
+}
+
+// JSONCodec is the default Codec. Note that interface{} Data decodes into the generic
+// JSON types (map[string]interface{}, float64...), so use a TypedQueue or a custom
+// Codec to get structs back.
+type JSONCodec[T any] struct{}
+
+// Encode to marshal the data as JSON
+func (JSONCodec[T]) Encode(data T) ([]byte, error) {
+	return json.Marshal(data)
+}
+
+// Decode to unmarshal the JSON data
+func (JSONCodec[T]) Decode(b []byte) (T, error) {
+	var data T
+	err := json.Unmarshal(b, &data)
+	return data, err
+}
+
+// SyncPolicy to decide when the WAL is flushed to disk with fsync
+type SyncPolicy int
+
+const (
+	SyncAlways   SyncPolicy = iota // fsync after every write
+	SyncInterval                   // fsync at most once every SyncEvery
+	SyncNever                      // leave it to the operating system
+)
+
+// TypedWAL is an on-disk write-ahead log of the payloads held by a queue. Every appended
+// payload is written to the current segment file in Dir and acknowledged once its Work
+// succeeds (or it is dead-lettered). Acknowledged payloads are compacted away, and the
+// payloads still pending are replayed by the queue's Start.
+type TypedWAL[T any] struct {
+	Dir         string
+	Codec       Codec[T]   // Default is JSONCodec
+	Sync        SyncPolicy // Default is SyncAlways
+	SyncEvery   time.Duration
+	SegmentSize int64 // bytes. Default is 16MB
+	walMutex    sync.Mutex
+	file        *os.File
+	size        int64
+	segment     int
+	seq         int64
+	pending     map[string]walRecord
+	acked       int // acknowledged since the last compaction
+	lastSync    time.Time
+}
+
+// WAL is the interface{}-based TypedWAL used by Queue and RateQueue.
+type WAL = TypedWAL[interface{}]
+
+// walRecord is one line of a segment file
+type walRecord struct {
+	Seq         int64        `json:"seq"`
+	Op          string       `json:"op"` // "add" or "ack"
+	Id          string       `json:"id"`
+	Data        []byte       `json:"data,omitempty"`
+	Attempts    int          `json:"attempts,omitempty"`
+	Result      int          `json:"result,omitempty"`
+	Queued      time.Time    `json:"queued,omitempty"`
+	LastAttempt time.Time    `json:"last_attempt,omitempty"`
+	Lane        string       `json:"lane,omitempty"`
+	NotBefore   time.Time    `json:"not_before,omitempty"`
+	Trace       TraceContext `json:"trace,omitempty"`
+}
+
+// Open to create Dir if needed and return the payloads pending in the existing segments,
+// in the order they were first appended.
+func (w *TypedWAL[T]) Open() ([]TypedPayload[T], error) {
+	w.walMutex.Lock()
+	defer w.walMutex.Unlock()
+	if w.Dir == "" {
+		return nil, errors.New("the WAL Dir is not supplied")
+	}
+	if w.Codec == nil {
+		w.Codec = JSONCodec[T]{}
+	}
+	if w.SegmentSize == 0 {
+		w.SegmentSize = 16 * 1024 * 1024
+	}
+	if w.SyncEvery == 0 {
+		w.SyncEvery = time.Second
+	}
+	if err := os.MkdirAll(w.Dir, 0755); err != nil {
+		return nil, err
+	}
+	segments, err := w.segments()
+	if err != nil {
+		return nil, err
+	}
+	w.pending = make(map[string]walRecord)
+	for _, s := range segments {
+		if err := w.load(s); err != nil {
+			return nil, err
+		}
+	}
+	// compact on open so the replayed payloads start from a single segment
+	if err := w.compact(); err != nil {
+		return nil, err
+	}
+	pls := make([]TypedPayload[T], 0, len(w.pending))
+	for _, r := range w.ordered() {
+		data, err := w.Codec.Decode(r.Data)
+		if err != nil {
+			return nil, fmt.Errorf("payload %s cannot be decoded: %w", r.Id, err)
+		}
+		pls = append(pls, TypedPayload[T]{
+			Id:          r.Id,
+			Data:        data,
+			Attempts:    r.Attempts,
+			Result:      r.Result,
+			Queued:      r.Queued,
+			LastAttempt: r.LastAttempt,
+			Lane:        r.Lane,
+			NotBefore:   r.NotBefore,
+			Trace:       r.Trace,
+		})
+	}
+	return pls, nil
+}
+
+// Append to log the payload. A payload already pending with the same attempt count is
+// not written again, so replayed payloads can be appended back into the queue.
+func (w *TypedWAL[T]) Append(p TypedPayload[T]) error {
+	w.walMutex.Lock()
+	defer w.walMutex.Unlock()
+	if w.file == nil {
+		return errors.New("the WAL is not open")
+	}
+	if r, ok := w.pending[p.Id]; ok && r.Attempts == p.Attempts {
+		return nil
+	}
+	data, err := w.Codec.Encode(p.Data)
+	if err != nil {
+		return err
+	}
+	w.seq++
+	r := walRecord{
+		Seq:         w.seq,
+		Op:          "add",
+		Id:          p.Id,
+		Data:        data,
+		Attempts:    p.Attempts,
+		Result:      p.Result,
+		Queued:      p.Queued,
+		LastAttempt: p.LastAttempt,
+		Lane:        p.Lane,
+		NotBefore:   p.NotBefore,
+		Trace:       p.Trace,
+	}
+	if old, ok := w.pending[p.Id]; ok {
+		// keep the original position for the replay order
+		r.Seq = old.Seq
+	}
+	if err := w.write(r); err != nil {
+		return err
+	}
+	w.pending[p.Id] = r
+	return nil
+}
+
+// Ack to mark the payloads as done. The log is compacted once enough of it is acknowledged.
+func (w *TypedWAL[T]) Ack(ids ...string) error {
+	w.walMutex.Lock()
+	defer w.walMutex.Unlock()
+	if w.file == nil {
+		return errors.New("the WAL is not open")
+	}
+	for _, id := range ids {
+		if _, ok := w.pending[id]; !ok {
+			continue
+		}
+		w.seq++
+		if err := w.write(walRecord{Seq: w.seq, Op: "ack", Id: id}); err != nil {
+			return err
+		}
+		delete(w.pending, id)
+		w.acked++
+	}
+	if len(w.pending) == 0 || w.acked >= 1000 && w.acked >= len(w.pending) {
+		return w.compact()
+	}
+	return nil
+}
+
+// Size to return the number of payloads pending in the log
+func (w *TypedWAL[T]) Size() int {
+	w.walMutex.Lock()
+	defer w.walMutex.Unlock()
+	return len(w.pending)
+}
+
+// Close to flush and close the current segment
+func (w *TypedWAL[T]) Close() error {
+	w.walMutex.Lock()
+	defer w.walMutex.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Sync()
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	w.file = nil
+	return err
+}
+
+// write to append the record to the current segment, rotating it when SegmentSize is reached
+func (w *TypedWAL[T]) write(r walRecord) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	if w.size+int64(len(b)) > w.SegmentSize && w.size > 0 {
+		// the full segment is kept for the replay. Ack compacts the log once enough
+		// of it is acknowledged.
+		if err := w.next(); err != nil {
+			return err
+		}
+	}
+	n, err := w.file.Write(b)
+	w.size += int64(n)
+	if err != nil {
+		return err
+	}
+	return w.sync(false)
+}
+
+// sync to fsync the current segment according to the SyncPolicy
+func (w *TypedWAL[T]) sync(force bool) error {
+	switch {
+	case force, w.Sync == SyncAlways:
+	case w.Sync == SyncInterval && time.Since(w.lastSync) >= w.SyncEvery:
+	default:
+		return nil
+	}
+	w.lastSync = time.Now()
+	return w.file.Sync()
+}
+
+// next to close the current segment and continue the log in a new one
+func (w *TypedWAL[T]) next() error {
+	if w.file != nil {
+		w.file.Close()
+	}
+	w.segment++
+	var err error
+	w.file, err = os.OpenFile(w.segmentPath(w.segment), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		w.file = nil
+		return err
+	}
+	w.size = 0
+	return nil
+}
+
+// compact to write the pending payloads into a new segment and remove the older ones
+func (w *TypedWAL[T]) compact() error {
+	old, err := w.segments()
+	if err != nil {
+		return err
+	}
+	if err := w.next(); err != nil {
+		return err
+	}
+	for _, r := range w.ordered() {
+		b, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		n, err := w.file.Write(append(b, '\n'))
+		w.size += int64(n)
+		if err != nil {
+			return err
+		}
+	}
+	if err := w.sync(true); err != nil {
+		return err
+	}
+	for _, s := range old {
+		if err := os.Remove(w.segmentPath(s)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	w.acked = 0
+	return nil
+}
+
+// load to apply the records of a segment to the pending payloads
+func (w *TypedWAL[T]) load(segment int) error {
+	f, err := os.Open(w.segmentPath(segment))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if segment > w.segment {
+		w.segment = segment
+	}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64*1024*1024)
+	for scanner.Scan() {
+		var r walRecord
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			// a torn write at the end of the last segment
+			break
+		}
+		if r.Seq > w.seq {
+			w.seq = r.Seq
+		}
+		switch r.Op {
+		case "add":
+			if old, ok := w.pending[r.Id]; ok {
+				r.Seq = old.Seq
+			}
+			w.pending[r.Id] = r
+		case "ack":
+			delete(w.pending, r.Id)
+		}
+	}
+	return scanner.Err()
+}
+
+// ordered to return the pending records in the order they were first appended
+func (w *TypedWAL[T]) ordered() []walRecord {
+	rs := make([]walRecord, 0, len(w.pending))
+	for _, r := range w.pending {
+		rs = append(rs, r)
+	}
+	sort.Slice(rs, func(i, j int) bool { return rs[i].Seq < rs[j].Seq })
+	return rs
+}
+
+// segments to list the segment numbers in Dir in ascending order
+func (w *TypedWAL[T]) segments() ([]int, error) {
+	entries, err := os.ReadDir(w.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var segments []int
+	for _, e := range entries {
+		var n int
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".wal") {
+			continue
+		}
+		if _, err := fmt.Sscanf(e.Name(), "%016d.wal", &n); err == nil {
+			segments = append(segments, n)
+		}
+	}
+	sort.Ints(segments)
+	return segments, nil
+}
+
+func (w *TypedWAL[T]) segmentPath(segment int) string {
+	return filepath.Join(w.Dir, fmt.Sprintf("%016d.wal", segment))
+}
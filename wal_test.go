@@ -0,0 +1,195 @@
+package payloadqueue_test
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sam-ish/payloadqueue"
+)
+
+func TestWAL(t *testing.T) {
+	type job struct {
+		Name string `json:"name"`
+	}
+
+	t.Run("Pending payloads are replayed in order", func(t *testing.T) {
+		dir := t.TempDir()
+		w := &payloadqueue.TypedWAL[job]{Dir: dir}
+		if _, err := w.Open(); err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		w.Append(payloadqueue.TypedPayload[job]{Id: "1", Data: job{Name: "Alpha"}})
+		w.Append(payloadqueue.TypedPayload[job]{Id: "2", Data: job{Name: "Beta"}})
+		w.Append(payloadqueue.TypedPayload[job]{Id: "3", Data: job{Name: "Gamma"}})
+		w.Append(payloadqueue.TypedPayload[job]{Id: "1", Data: job{Name: "Alpha"}, Attempts: 1})
+		w.Ack("2")
+		w.Close()
+
+		w = &payloadqueue.TypedWAL[job]{Dir: dir}
+		pls, err := w.Open()
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		if len(pls) != 2 || pls[0].Id != "1" || pls[1].Id != "3" {
+			t.Fatalf("Expected payloads 1 and 3, got %+v", pls)
+		}
+		if pls[0].Data.Name != "Alpha" || pls[0].Attempts != 1 {
+			t.Errorf("Unexpected payload %+v", pls[0])
+		}
+		w.Close()
+	})
+
+	t.Run("Acknowledged segments are compacted", func(t *testing.T) {
+		dir := t.TempDir()
+		w := &payloadqueue.TypedWAL[job]{Dir: dir, SegmentSize: 512, Sync: payloadqueue.SyncNever}
+		w.Open()
+		for i := 0; i < 50; i++ {
+			id := strconv.Itoa(i)
+			w.Append(payloadqueue.TypedPayload[job]{Id: id, Data: job{Name: "Job " + id}})
+			w.Ack(id)
+		}
+		w.Append(payloadqueue.TypedPayload[job]{Id: "last", Data: job{Name: "Last"}})
+		w.Close()
+
+		entries, _ := os.ReadDir(dir)
+		if len(entries) != 1 {
+			t.Errorf("Expected a single segment after compaction, got %d", len(entries))
+		}
+		w = &payloadqueue.TypedWAL[job]{Dir: dir}
+		if pls, _ := w.Open(); len(pls) != 1 || pls[0].Id != "last" {
+			t.Errorf("Expected only the last payload to be pending, got %+v", pls)
+		}
+		w.Close()
+	})
+
+	t.Run("Full segments rotate without rewriting the log", func(t *testing.T) {
+		dir := t.TempDir()
+		w := &payloadqueue.TypedWAL[job]{Dir: dir, SegmentSize: 4096, Sync: payloadqueue.SyncNever}
+		w.Open()
+		start := time.Now()
+		for i := 0; i < 3000; i++ {
+			id := strconv.Itoa(i)
+			w.Append(payloadqueue.TypedPayload[job]{Id: id, Data: job{Name: "Job " + id}})
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("Expected the appends not to rewrite the log, took %s", elapsed)
+		}
+		w.Close()
+
+		entries, _ := os.ReadDir(dir)
+		if len(entries) < 2 {
+			t.Errorf("Expected the log to span several segments, got %d", len(entries))
+		}
+		w = &payloadqueue.TypedWAL[job]{Dir: dir}
+		pls, _ := w.Open()
+		if len(pls) != 3000 || pls[0].Id != "0" || pls[2999].Id != "2999" {
+			t.Errorf("Expected the 3000 payloads to be replayed in order, got %d", len(pls))
+		}
+		w.Close()
+	})
+
+	t.Run("A torn write is ignored", func(t *testing.T) {
+		dir := t.TempDir()
+		w := &payloadqueue.TypedWAL[job]{Dir: dir}
+		w.Open()
+		w.Append(payloadqueue.TypedPayload[job]{Id: "1", Data: job{Name: "Alpha"}})
+		w.Close()
+
+		entries, _ := os.ReadDir(dir)
+		f, _ := os.OpenFile(filepath.Join(dir, entries[0].Name()), os.O_APPEND|os.O_WRONLY, 0644)
+		f.WriteString(`{"seq":9,"op":"add","id":"2","da`)
+		f.Close()
+
+		w = &payloadqueue.TypedWAL[job]{Dir: dir}
+		pls, err := w.Open()
+		if err != nil || len(pls) != 1 {
+			t.Errorf("Expected 1 payload, got %d and %v", len(pls), err)
+		}
+		w.Close()
+	})
+}
+
+func TestQueueWAL(t *testing.T) {
+	type job struct {
+		Name string `json:"name"`
+	}
+
+	t.Run("Buffered payloads survive a restart", func(t *testing.T) {
+		dir := t.TempDir()
+		q := &payloadqueue.TypedQueue[job]{
+			MaxSize: 3,
+			MaxAge:  10,
+			Tag:     "QueueWAL",
+			WAL:     &payloadqueue.TypedWAL[job]{Dir: dir},
+			Work:    func(jobs []job) int { return 0 },
+		}
+		if err := q.Start(); err != nil {
+			t.Fatalf("Unexpected error: %s", err.Error())
+		}
+		q.Append(q.NewPayload(job{Name: "Alpha"}))
+		q.Append(q.NewPayload(job{Name: "Beta"}))
+		// a crash: Close would flush the buffered payloads
+		q.WAL.Close()
+
+		var runMutex sync.Mutex
+		var got []job
+		q = &payloadqueue.TypedQueue[job]{
+			MaxSize: 2,
+			MaxAge:  10,
+			Tag:     "QueueWAL",
+			WAL:     &payloadqueue.TypedWAL[job]{Dir: dir},
+			Work: func(jobs []job) int {
+				runMutex.Lock()
+				got = append(got, jobs...)
+				runMutex.Unlock()
+				return 0
+			},
+		}
+		q.Start()
+		q.Close()
+
+		runMutex.Lock()
+		if len(got) != 2 || got[0].Name != "Alpha" || got[1].Name != "Beta" {
+			t.Errorf("Expected Alpha and Beta to be replayed, got %+v", got)
+		}
+		runMutex.Unlock()
+		w := &payloadqueue.TypedWAL[job]{Dir: dir}
+		if pls, _ := w.Open(); len(pls) != 0 {
+			t.Errorf("Expected the WAL to be empty after Work succeeded, got %d", len(pls))
+		}
+		w.Close()
+	})
+
+	t.Run("RateQueue replays discarded payloads", func(t *testing.T) {
+		dir := t.TempDir()
+		q := &payloadqueue.TypedRateQueue[job]{
+			RequestsPerSecond: 1,
+			Tag:               "RateQueueWAL",
+			DiscardOnClose:    true,
+			WAL:               &payloadqueue.TypedWAL[job]{Dir: dir},
+			Work:              func(j job) int { return 0 },
+		}
+		q.Start()
+		q.Append(q.NewPayload(job{Name: "Alpha"}))
+		q.Close()
+
+		q = &payloadqueue.TypedRateQueue[job]{
+			RequestsPerSecond: 1,
+			Tag:               "RateQueueWAL",
+			WAL:               &payloadqueue.TypedWAL[job]{Dir: dir},
+			Work:              func(j job) int { return 0 },
+		}
+		q.Start()
+		if q.Size() != 1 {
+			t.Errorf("Expected q.Size() to be 1, got %d", q.Size())
+		}
+		q.Close()
+		if q.WAL.Size() != 0 {
+			t.Errorf("Expected the WAL to be empty after Close, got %d", q.WAL.Size())
+		}
+	})
+}